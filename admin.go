@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+// Guards admin endpoints behind the ADMIN_SECRET api key. If no secret is configured, admin endpoints are unreachable.
+func middlewareAdmin(adminSecret string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "ApiKey ")
+		if adminSecret == "" || subtle.ConstantTimeCompare([]byte(key), []byte(adminSecret)) != 1 {
+			respondWithError(w, 401, "Invalid admin key", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleGetLockouts(db *database.DB, throttle *loginThrottle) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events, err := db.GetLockoutEvents()
+		if err != nil {
+			respondWithError(w, 500, "Error getting lockout events", err)
+			return
+		}
+
+		type response struct {
+			Active []lockout               `json:"active"`
+			Events []database.LockoutEvent `json:"events"`
+		}
+		respondWithJSON(w, 200, response{Active: throttle.lockedOut(), Events: events})
+	})
+}
+
+func handlePostUnlock(db *database.DB, throttle *loginThrottle) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		type parameters struct {
+			Email string `json:"email"`
+			IP    string `json:"ip"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		log.Println(rid, "handlePostUnlock", params.Email, params.IP)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+		if params.Email == "" && params.IP == "" {
+			respondWithError(w, 400, "Either email or ip is required", nil)
+			return
+		}
+
+		keys := []string{}
+		if params.Email != "" {
+			keys = append(keys, accountThrottleKey(params.Email))
+		}
+		if params.IP != "" {
+			keys = append(keys, ipThrottleKey(params.IP))
+		}
+
+		type response struct {
+			Unlocked []string `json:"unlocked"`
+		}
+		resp := response{Unlocked: []string{}}
+		for _, k := range keys {
+			if !throttle.unlock(k) {
+				continue
+			}
+			resp.Unlocked = append(resp.Unlocked, k)
+			err = db.RecordLockoutEvent(database.LockoutEvent{Event: database.LockoutEventUnlocked, Key: k})
+			if err != nil {
+				log.Println(rid, "Error recording unlock event", err)
+			}
+		}
+		respondWithJSON(w, 200, resp)
+	})
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,6 +12,8 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/mailer"
+	"github.com/madsbv/go-server-exercise/internal/password"
 )
 
 const expirationAccessSeconds = 60 * 60            // 1 hour
@@ -18,7 +21,7 @@ const expirationRefreshSeconds = 60 * 60 * 24 * 60 // 60 days
 const accessIssuer = "chirpy-access"
 const refreshIssuer = "chirpy-refresh"
 
-func handlePostLogin(db *database.DB, jwtSecret []byte) http.Handler {
+func handlePostLogin(db *database.DB, jwtSecret []byte, throttle *loginThrottle, respond loginResponder) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rid := getRequestID(w)
 
@@ -28,14 +31,6 @@ func handlePostLogin(db *database.DB, jwtSecret []byte) http.Handler {
 			Expiration int    `json:"expires_in_seconds"`
 		}
 
-		type response struct {
-			Email        string `json:"email"`
-			Id           int    `json:"id"`
-			IsChirpyRed  bool   `json:"is_chirpy_red"`
-			Token        string `json:"token"`
-			RefreshToken string `json:"refresh_token"`
-		}
-
 		decoder := json.NewDecoder(r.Body)
 		params := parameters{}
 		err := decoder.Decode(&params)
@@ -46,52 +41,178 @@ func handlePostLogin(db *database.DB, jwtSecret []byte) http.Handler {
 			return
 		}
 
+		accountKey := accountThrottleKey(params.Email)
+		keys := []string{accountKey, ipThrottleKey(clientIP(r))}
+		attempt, wait := throttle.reserve(keys...)
+		if wait > 0 {
+			log.Println(rid, "Login attempt throttled for", wait)
+			respondWithTooManyRequests(w, wait, "Too many failed login attempts, try again later")
+			return
+		}
+
 		user, err := db.ValidateLogin(params.Email, params.Password)
 		if err != nil {
 			log.Println(rid, "Error while validating login", err)
+			recordFailedLogin(rid, db, attempt)
 			respondWithError(w, 401, "Error handling request", err)
 			return
 		}
+		attempt.succeed(accountKey)
+		if rejectSanctioned(w, db, user.Id) {
+			return
+		}
+
+		totpState, err := db.GetTOTP(user.Id)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		if totpState.Enabled() {
+			mfaToken, err := newToken(fmt.Sprint(user.Id), mfaIssuer, expirationMFASeconds, jwtSecret)
+			if err != nil {
+				respondWithError(w, 500, "Error creating MFA token", err)
+				return
+			}
+			log.Println(rid, "Password accepted, awaiting second factor for user", user.Id)
+
+			type response struct {
+				MFARequired bool   `json:"mfa_required"`
+				MFAToken    string `json:"mfa_token"`
+			}
+			respondWithJSON(w, 200, response{MFARequired: true, MFAToken: mfaToken})
+			return
+		}
 
 		expiration := expirationAccessSeconds
 		if params.Expiration > 0 && params.Expiration < expiration {
 			expiration = params.Expiration
 		}
 
-		jwt, err := newToken(fmt.Sprint(user.Id), accessIssuer, expiration, jwtSecret)
+		resp, err := issueLoginTokens(db, user, expiration, jwtSecret, r)
 		if err != nil {
-			log.Printf(rid, "Error creating jwt", err, params)
+			log.Println(rid, "Error creating tokens", err)
 			respondWithError(w, 500, "Error handling request", err)
 			return
 		}
+		respond(w, resp)
+	})
+}
+
+// Counts a failed login against the throttle keys, and records any lockouts that result.
+func recordFailedLogin(rid string, db *database.DB, attempt *loginReservation) {
+	for _, l := range attempt.fail() {
+		log.Println(rid, "Locking out", l.Key, "until", l.LockedUntil)
+		err := db.RecordLockoutEvent(database.LockoutEvent{Event: database.LockoutEventLocked, Key: l.Key, Failures: l.Failures, LockedUntil: &l.LockedUntil})
+		if err != nil {
+			log.Println(rid, "Error recording lockout event", err)
+		}
+	}
+}
+
+type loginResponse struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Id            int    `json:"id"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+	Token         string `json:"token"`
+	RefreshToken  string `json:"refresh_token"`
+	SessionId     string `json:"-"`
+}
+
+// Issues an access and refresh token pair to a user who has fully authenticated, starting a new session.
+func issueLoginTokens(db *database.DB, user database.SafeUser, expiration int, jwtSecret []byte, r *http.Request) (loginResponse, error) {
+	claims := tokenClaims{}
+	jwt, jwtRefresh, err := issueSessionTokens(db, user.Id, &claims, expiration, jwtSecret, r)
+	if err != nil {
+		return loginResponse{}, err
+	}
+	return loginResponse{
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Id:            user.Id,
+		IsChirpyRed:   user.IsChirpyRed,
+		Token:         jwt,
+		RefreshToken:  jwtRefresh,
+		SessionId:     claims.SessionId,
+	}, nil
+}
+
+// Starts a new session for the user and returns its access and refresh tokens. The scope and client of the given claims carry over to both tokens, and the generation and session are filled in.
+func issueSessionTokens(db *database.DB, userId int, claims *tokenClaims, expiration int, jwtSecret []byte, r *http.Request) (access, refresh string, err error) {
+	claims.Generation, err = db.GetTokenGeneration(userId)
+	if err != nil {
+		return "", "", err
+	}
+	claims.SessionId, err = newRandomId()
+	if err != nil {
+		return "", "", err
+	}
 
-		jwtRefresh, err := newToken(fmt.Sprint(user.Id), refreshIssuer, expirationRefreshSeconds, jwtSecret)
+	access, err = newTokenWithClaims(*claims, fmt.Sprint(userId), accessIssuer, expiration, jwtSecret)
+	if err != nil {
+		return "", "", err
+	}
 
-		respondWithJSON(w, 200, response{
-			Email:        user.Email,
-			Id:           user.Id,
-			IsChirpyRed:  user.IsChirpyRed,
-			Token:        jwt,
-			RefreshToken: jwtRefresh,
-		})
+	issuedAt := time.Now()
+	refresh, err = newTokenWithClaims(*claims, fmt.Sprint(userId), refreshIssuer, expirationRefreshSeconds, jwtSecret)
+	if err != nil {
+		return "", "", err
+	}
+	err = db.RecordRefreshToken(refresh, database.RefreshToken{
+		SessionId:  claims.SessionId,
+		UserId:     userId,
+		IssuedAt:   issuedAt,
+		ExpiresAt:  issuedAt.Add(expirationRefreshSeconds * time.Second),
+		LastUsedAt: issuedAt,
+		UserAgent:  r.UserAgent(),
+		IP:         clientIP(r),
+		ClientId:   claims.ClientId,
 	})
+	if err != nil {
+		return "", "", err
+	}
+	return access, refresh, nil
+}
+
+// Claims that Chirpy adds to the registered ones
+type tokenClaims struct {
+	// The user's token generation when the token was issued
+	Generation int `json:"gen"`
+	// The session that an access or refresh token belongs to
+	SessionId string `json:"sid,omitempty"`
+	// Space separated scopes of tokens granted to OAuth clients. Tokens without a client carry every scope.
+	Scope    string `json:"scope,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+	jwt.RegisteredClaims
 }
 
 func newToken(id, issuer string, expirationSeconds int, key []byte) (string, error) {
-	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+	return newTokenWithClaims(tokenClaims{}, id, issuer, expirationSeconds, key)
+}
+
+// Fills in the registered claims and signs the token. Every token gets a unique ID, so that it can be revoked individually.
+func newTokenWithClaims(claims tokenClaims, id, issuer string, expirationSeconds int, key []byte) (string, error) {
+	tokenId, err := newRandomId()
+	if err != nil {
+		return "", err
+	}
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        tokenId,
 		Issuer:    issuer,
 		IssuedAt:  jwt.NewNumericDate(time.Now()),
 		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expirationSeconds) * time.Second)),
 		Subject:   fmt.Sprint(id),
-	}).SignedString(key)
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
 }
 
-func handlePutUsers(db *database.DB, jwtSecret []byte) http.Handler {
+func handlePutUsers(db *database.DB, auth *authenticator, policy *password.Policy, m mailer.Mailer, jwtSecret []byte) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rid := getRequestID(w)
 		type parameters struct {
-			Email    string `json:"email"`
-			Password string `json:"password"`
+			Email           string `json:"email"`
+			Password        string `json:"password"`
+			CurrentPassword string `json:"current_password"`
 		}
 		decoder := json.NewDecoder(r.Body)
 		params := parameters{}
@@ -102,35 +223,43 @@ func handlePutUsers(db *database.DB, jwtSecret []byte) http.Handler {
 			return
 		}
 
-		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-		log.Println(rid, "Received token", tokenString)
-
-		token, err := validateToken(tokenString, accessIssuer, jwtSecret)
+		// Changing the email and password hands over the account, so delegated tokens can't do it, and neither can a stolen access token on its own
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		id := info.UserId
+		err = db.CheckPassword(id, params.CurrentPassword)
 		if err != nil {
-			respondWithError(w, 401, "Invalid token", err)
+			respondWithError(w, 401, "Current password is incorrect", err)
 			return
 		}
 
-		var idStr string
-		idStr, err = token.Claims.GetSubject()
+		err = validateEmail(params.Email)
 		if err != nil {
-			respondWithError(w, 401, "No user ID given", err)
+			respondWithError(w, 400, "Invalid email address", err)
 			return
 		}
 
-		var id int
-		id, err = strconv.Atoi(idStr)
+		err = policy.Validate(params.Email, params.Password)
 		if err != nil {
-			respondWithError(w, 401, "Given user ID is not a number", err)
+			respondWithPolicyError(w, err)
 			return
 		}
 
 		var user database.SafeUser
 		user, err = db.UpdateUser(id, params.Email, params.Password)
+		if errors.Is(err, database.ErrEmailTaken) {
+			respondWithError(w, 409, err.Error(), err)
+			return
+		}
 		if err != nil {
 			respondWithError(w, 500, "Failed to update user (ID might be incorrect)", err)
 			return
 		}
+		if !user.EmailVerified {
+			trySendVerificationEmail(rid, m, user, jwtSecret)
+		}
 		respondWithJSON(w, 200, user)
 	})
 }
@@ -151,22 +280,40 @@ func handlePostRefresh(db *database.DB, jwtSecret []byte) http.Handler {
 			return
 		}
 
-		token, err := validateToken(tokenString, refreshIssuer, jwtSecret)
+		claims, err := validateToken(tokenString, refreshIssuer, jwtSecret)
 		if err != nil {
 			respondWithError(w, 401, "Invalid token", err)
 			return
 		}
 
-		var idStr string
-		idStr, err = token.Claims.GetSubject()
+		id, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			respondWithError(w, 401, "Given user ID is not a number", err)
+			return
+		}
+		err = checkTokenGeneration(db, id, claims.Generation)
+		if err != nil {
+			respondWithError(w, 401, "Token is revoked", err)
+			return
+		}
+		if rejectSanctioned(w, db, id) {
+			return
+		}
+
+		_, err = db.UseRefreshToken(tokenString, clientIP(r), r.UserAgent())
+		if errors.Is(err, database.ErrSessionNotFound) {
+			respondWithError(w, 401, "Session not found", err)
+			return
+		}
 		if err != nil {
-			respondWithError(w, 401, "No user ID given", err)
+			respondWithError(w, 500, "Potential database error", err)
 			return
 		}
 
-		jwt, err := newToken(idStr, accessIssuer, expirationAccessSeconds, jwtSecret)
+		jwt, err := refreshAccessToken(*claims, jwtSecret)
 		if err != nil {
 			respondWithError(w, 500, "Error creating access token", err)
+			return
 		}
 
 		type response struct {
@@ -177,22 +324,39 @@ func handlePostRefresh(db *database.DB, jwtSecret []byte) http.Handler {
 	})
 }
 
-func validateToken(tokenString string, requiredIssuer string, key []byte) (*jwt.Token, error) {
+// Issues a new access token for the session of a refresh token, keeping the scope and client it was granted with.
+func refreshAccessToken(refreshClaims tokenClaims, jwtSecret []byte) (string, error) {
+	claims := tokenClaims{Generation: refreshClaims.Generation, SessionId: refreshClaims.SessionId, Scope: refreshClaims.Scope, ClientId: refreshClaims.ClientId}
+	return newTokenWithClaims(claims, refreshClaims.Subject, accessIssuer, expirationAccessSeconds, jwtSecret)
+}
+
+func validateToken(tokenString string, requiredIssuer string, key []byte) (*tokenClaims, error) {
+	claims := tokenClaims{}
 	// The keyFunc should take the parsed but unverified token, do any checks to make sure the token is of a valid format, and then return the signing key to verify the authenticity of the token against.
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
 		if issuer, err := token.Claims.GetIssuer(); issuer != requiredIssuer || err != nil {
 			return nil, fmt.Errorf("Invalid token type %v, %v", issuer, err)
 		}
 		return key, nil
 	}, jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
 }
 
-func checkUserAuthenticated(tokenString string, key []byte) (string, error) {
-	token, err := validateToken(tokenString, accessIssuer, key)
+var errTokenGenerationRevoked = errors.New("Token was revoked by logging out everywhere")
+
+// Rejects tokens issued before the user last logged out everywhere.
+func checkTokenGeneration(db *database.DB, userId, generation int) error {
+	current, err := db.GetTokenGeneration(userId)
 	if err != nil {
-		return "", err
+		return err
+	}
+	if generation != current {
+		return errTokenGenerationRevoked
 	}
-	return token.Claims.GetSubject()
+	return nil
 }
 
 func handlePostRevoke(db *database.DB, jwtSecret []byte) http.Handler {
@@ -201,18 +365,17 @@ func handlePostRevoke(db *database.DB, jwtSecret []byte) http.Handler {
 		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 		log.Println(rid, "handlePostRevoke", tokenString)
 
-		token, err := validateToken(tokenString, refreshIssuer, jwtSecret)
+		claims, err := validateToken(tokenString, refreshIssuer, jwtSecret)
 		if err != nil {
 			respondWithError(w, 401, "Invalid token", err)
 			return
 		}
-		issuedAt, err := token.Claims.GetIssuedAt()
-		if err != nil {
-			respondWithError(w, 401, "Invalid token", err)
+		if claims.ExpiresAt == nil {
+			respondWithError(w, 401, "Invalid token", nil)
 			return
 		}
 
-		err = db.RevokeToken(tokenString, issuedAt.Time)
+		err = db.RevokeToken(tokenString, claims.ExpiresAt.Time)
 		if err != nil {
 			respondWithError(w, 500, "Potential database error", err)
 			return
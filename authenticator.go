@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+// Scopes that can be granted to personal access tokens. Credentials are not part of the profile, see scopeAccount.
+const scopeChirpsRead = "chirps:read"
+const scopeChirpsWrite = "chirps:write"
+const scopeProfileWrite = "profile:write"
+
+// Managing credentials and security settings requires a full login, so this scope is never granted to tokens
+const scopeAccount = "account"
+
+var grantableScopes = []string{scopeChirpsRead, scopeChirpsWrite, scopeProfileWrite}
+
+const personalAccessTokenPrefix = "chirpy_pat_"
+
+var errInsufficientScope = errors.New("Token lacks the required scope")
+var errTokenDenied = errors.New("Token has been revoked")
+
+// Resolves the credentials presented with a request to a user. Every authenticated route goes through here, so that all kinds of tokens are accepted and checked the same way.
+type authenticator struct {
+	db        *database.DB
+	jwtSecret []byte
+	denylist  *tokenDenylist
+}
+
+const tokenTypeAccess = "access_token"
+const tokenTypeRefresh = "refresh_token"
+const tokenTypePersonal = "personal_access_token"
+
+type authInfo struct {
+	UserId int
+	// One of the tokenType constants
+	TokenType string
+	// nil for tokens issued by logging in, which carry every scope
+	Scopes []string
+	// The login session that issued the token, if any
+	SessionId string
+	// The OAuth client the token was granted to, if any
+	ClientId string
+	// The ID of a JWT, so that it can be denylisted
+	TokenId   string
+	Issuer    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// An empty scope only requires that the request is authenticated at all.
+func (a authInfo) hasScope(scope string) bool {
+	return scope == "" || a.Scopes == nil || slices.Contains(a.Scopes, scope)
+}
+
+// Authenticates the request and checks that its credentials carry the scope. Browsers may send their access token as a cookie instead of a header, in which case requests that change anything need a CSRF token.
+func (a *authenticator) authenticate(r *http.Request, scope string) (authInfo, error) {
+	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	fromCookie := false
+	if tokenString == "" {
+		if c, err := r.Cookie(accessCookieName); err == nil {
+			tokenString, fromCookie = c.Value, true
+		}
+	}
+	info, err := a.inspect(tokenString, true)
+	if err != nil {
+		return authInfo{}, err
+	}
+	if info.TokenType == tokenTypeRefresh {
+		return authInfo{}, errors.New("Refresh tokens can only be used to get new access tokens")
+	}
+	if fromCookie && !safeMethod(r.Method) {
+		err = a.checkCSRF(r, info.SessionId)
+		if err != nil {
+			return authInfo{}, err
+		}
+	}
+	if !info.hasScope(scope) {
+		return info, errInsufficientScope
+	}
+	return info, nil
+}
+
+// Checks that any kind of token is valid and hasn't been revoked in any way, and describes it. If recordUse is set, personal access tokens are marked as used.
+func (a *authenticator) inspect(tokenString string, recordUse bool) (authInfo, error) {
+	if strings.HasPrefix(tokenString, personalAccessTokenPrefix) {
+		lookup := a.db.LookupPersonalAccessToken
+		if recordUse {
+			lookup = a.db.UsePersonalAccessToken
+		}
+		pat, err := lookup(hashToken(tokenString))
+		if err != nil {
+			return authInfo{}, err
+		}
+		err = checkSanction(a.db, pat.UserId)
+		if err != nil {
+			return authInfo{}, err
+		}
+		info := authInfo{UserId: pat.UserId, TokenType: tokenTypePersonal, Scopes: pat.Scopes, Issuer: accessIssuer, IssuedAt: pat.CreatedAt}
+		if pat.ExpiresAt != nil {
+			info.ExpiresAt = *pat.ExpiresAt
+		}
+		return info, nil
+	}
+
+	tokenType := tokenTypeAccess
+	claims, err := validateToken(tokenString, accessIssuer, a.jwtSecret)
+	if err != nil {
+		tokenType = tokenTypeRefresh
+		claims, err = validateToken(tokenString, refreshIssuer, a.jwtSecret)
+	}
+	if err != nil {
+		return authInfo{}, err
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return authInfo{}, err
+	}
+
+	if tokenType == tokenTypeAccess && claims.ID != "" && a.denylist.denied(claims.ID) {
+		return authInfo{}, errTokenDenied
+	}
+	if tokenType == tokenTypeAccess && claims.SessionId != "" {
+		active, err := a.db.SessionActive(claims.SessionId)
+		if err != nil {
+			return authInfo{}, err
+		}
+		if !active {
+			return authInfo{}, errTokenDenied
+		}
+	}
+	if tokenType == tokenTypeRefresh {
+		revoked, err := a.db.TokenRevoked(tokenString)
+		if err != nil {
+			return authInfo{}, err
+		}
+		if revoked {
+			return authInfo{}, errTokenDenied
+		}
+		_, err = a.db.GetRefreshToken(tokenString)
+		if err != nil {
+			return authInfo{}, err
+		}
+	}
+	err = checkTokenGeneration(a.db, id, claims.Generation)
+	if err != nil {
+		return authInfo{}, err
+	}
+	// Suspensions apply to tokens issued before them as well
+	err = checkSanction(a.db, id)
+	if err != nil {
+		return authInfo{}, err
+	}
+
+	info := authInfo{UserId: id, TokenType: tokenType, SessionId: claims.SessionId, ClientId: claims.ClientId, TokenId: claims.ID, Issuer: claims.Issuer}
+	if claims.ClientId != "" {
+		// Deleting a client cuts off its access tokens immediately, not just its sessions
+		_, err = a.db.GetOAuthClient(claims.ClientId)
+		if err != nil {
+			return authInfo{}, err
+		}
+		info.Scopes = strings.Fields(claims.Scope)
+	}
+	if claims.IssuedAt != nil {
+		info.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		info.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return info, nil
+}
+
+// For routes that anyone may use but that show more to authenticated users. Requests without valid credentials carrying the scope are served as anonymous with a zero authInfo, rather than failing like they would with require, so that a stale token doesn't lock clients out of public routes.
+func (a *authenticator) optional(r *http.Request, scope string) authInfo {
+	if r.Header.Get("Authorization") == "" {
+		if _, err := r.Cookie(accessCookieName); err != nil {
+			return authInfo{}
+		}
+	}
+	info, err := a.authenticate(r, scope)
+	if err != nil {
+		return authInfo{}
+	}
+	return info
+}
+
+// Authenticates the request, or responds with an error and returns false.
+func (a *authenticator) require(w http.ResponseWriter, r *http.Request, scope string) (authInfo, bool) {
+	info, err := a.authenticate(r, scope)
+	if errors.Is(err, errInsufficientScope) {
+		respondWithError(w, 403, "Token lacks the "+scope+" scope", err)
+		return info, false
+	}
+	var serr *sanctionError
+	if errors.As(err, &serr) {
+		respondWithSanction(w, serr)
+		return info, false
+	}
+	if errors.Is(err, errInvalidCSRFToken) {
+		respondWithError(w, 403, "Missing or invalid CSRF token", err)
+		return info, false
+	}
+	if err != nil {
+		respondWithError(w, 401, "Authentication failed", err)
+		return info, false
+	}
+	return info, true
+}
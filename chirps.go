@@ -2,41 +2,47 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/entities"
+	"github.com/madsbv/go-server-exercise/internal/moderation"
+	"github.com/madsbv/go-server-exercise/internal/trends"
 )
 
 type Chirp = database.Chirp
 
-func handlePostChirps(db *database.DB, jwtSecret []byte) http.Handler {
+func handlePostChirps(db *database.DB, auth *authenticator, requireVerifiedEmail bool, tracker *trends.Tracker, filter *moderation.Filter) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rid := getRequestID(w)
-		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-		idStr, err := checkUserAuthenticated(tokenString, jwtSecret)
-		log.Println(rid, "handlePostChirps for AuthorId", idStr, tokenString)
-		if err != nil {
-			respondWithError(w, 401, "Authentication failed", err)
+		info, ok := auth.require(w, r, scopeChirpsWrite)
+		if !ok {
 			return
 		}
+		authorId := info.UserId
+		log.Println(rid, "handlePostChirps for AuthorId", authorId)
 
-		authorId, err := strconv.Atoi(idStr)
-		if err != nil {
-			respondWithError(w, 401, "Given user ID is not a number", err)
+		if !requireVerified(w, db, requireVerifiedEmail, authorId) {
 			return
 		}
 
 		type parameters struct {
-			Body string `json:"body"`
+			Body      string   `json:"body"`
+			InReplyTo int      `json:"in_reply_to"`
+			QuoteOf   int      `json:"quote_of"`
+			MediaIds  []string `json:"media_ids"`
 		}
 
 		decoder := json.NewDecoder(r.Body)
 		params := parameters{}
-		err = decoder.Decode(&params)
+		err := decoder.Decode(&params)
 		log.Printf("Handling: %s", params.Body)
 		if err != nil {
 			log.Printf("Error decoding chirp parameters: %s", err)
@@ -44,36 +50,69 @@ func handlePostChirps(db *database.DB, jwtSecret []byte) http.Handler {
 			return
 		}
 
-		if l := len(params.Body); l > 140 {
-			log.Printf("Received chirp with %d > 140 characters, rejected", l)
-			respondWithError(w, 400, "Chirp is too long", err)
+		body, flags, err := prepareChirpBody(filter, params.Body)
+		if err != nil {
+			respondWithBodyError(w, err)
+			return
+		}
+		err = validateChirpMedia(params.MediaIds)
+		if err != nil {
+			respondWithError(w, 400, err.Error(), err)
+			return
+		}
+		ents, err := extractEntities(db, body)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		chirp, err := db.CreateChirp(Chirp{Body: body, AuthorId: authorId, InReplyTo: params.InReplyTo, QuoteOf: params.QuoteOf, Entities: ents, MediaIds: params.MediaIds})
+		if errors.Is(err, database.ErrParentNotFound) || errors.Is(err, database.ErrQuotedNotFound) || errors.Is(err, database.ErrMediaNotFound) {
+			respondWithError(w, 400, err.Error(), err)
 			return
 		}
-
-		// Chirp has valid length, proceed to clean it up
-		body := cleanBadWords(strings.TrimSpace(params.Body))
-		chirp, err := db.CreateChirp(body, authorId)
 		if err != nil {
 			log.Printf("Database error when creating chirp: %v", err)
 			respondWithError(w, 500, "Error handling request", err)
 			return
 		}
+		flagChirp(w, db, chirp.Id, flags)
+		recordTrends(tracker, chirp)
 
 		respondWithJSON(w, 201, chirp)
 	})
 }
 
-func handleGetAllChirps(db *database.DB) http.Handler {
+// Responds with an error and returns false if posting requires a verified email address and the user hasn't verified theirs. Applies to everything that puts content under the user's name: new chirps, replies, quotes, rechirps and edits.
+func requireVerified(w http.ResponseWriter, db *database.DB, requireVerifiedEmail bool, userId int) bool {
+	if !requireVerifiedEmail {
+		return true
+	}
+	user, err := db.GetUser(userId)
+	if err != nil {
+		respondWithError(w, 401, "Author not found", err)
+		return false
+	}
+	if !user.EmailVerified {
+		respondWithError(w, 403, "Email address must be verified before posting chirps", nil)
+		return false
+	}
+	return true
+}
+
+func handleGetAllChirps(db *database.DB, auth *authenticator) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := auth.optional(r, scopeChirpsRead)
 		chirps, err := db.GetSortedChirps()
 		if err != nil {
 			log.Println("Error getting list of chirps")
 			respondWithError(w, 500, "Error handling request", err)
+			return
 		}
 		if s := r.URL.Query().Get("author_id"); len(s) > 0 {
 			authorId, err := strconv.Atoi(s)
 			if err != nil {
 				respondWithError(w, 404, "Given author id does not look like a number", err)
+				return
 			}
 			authorChirps := make([]database.Chirp, 0)
 			for _, c := range chirps {
@@ -88,17 +127,25 @@ func handleGetAllChirps(db *database.DB) http.Handler {
 			slices.Reverse(chirps)
 
 		}
+		err = markLikedByMe(db, info, chirps)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
 
 		respondWithJSON(w, 200, chirps)
 	})
 }
 
-func handleGetChirp(db *database.DB) http.Handler {
+func handleGetChirp(db *database.DB, auth *authenticator) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := auth.optional(r, scopeChirpsRead)
 		requestedId := r.PathValue("id")
 		id, err := strconv.Atoi(requestedId)
 		if err != nil {
 			log.Printf("Error serving GetChirp request for requested id %v: Looks like it is not an integer", requestedId)
+			respondWithError(w, 400, "Given chirp ID is not a number", err)
+			return
 		}
 
 		chirp, err := db.GetChirp(id)
@@ -106,29 +153,28 @@ func handleGetChirp(db *database.DB) http.Handler {
 			log.Println("Error getting chirp with id", id, err)
 			// NOTE: It might be worth distinguishing between internal database error, and invalid id in request. How to do that?
 			respondWithError(w, 404, "Error handling request", err)
+			return
+		}
+		err = markChirpLikedByMe(db, info, &chirp)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
 		}
 
 		respondWithJSON(w, 200, chirp)
 	})
 }
 
-func handleDeleteChirp(db *database.DB, jwtSecret []byte) http.Handler {
+func handleDeleteChirp(db *database.DB, auth *authenticator, tracker *trends.Tracker) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rid := getRequestID(w)
 
-		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-		idStr, err := checkUserAuthenticated(tokenString, jwtSecret)
-		log.Println(rid, "handleDeleteChirp for AuthorId", idStr, tokenString)
-		if err != nil {
-			respondWithError(w, 401, "Authentication failed", err)
-			return
-		}
-
-		authorId, err := strconv.Atoi(idStr)
-		if err != nil {
-			respondWithError(w, 401, "Given user ID is not a number", err)
+		info, ok := auth.require(w, r, scopeChirpsWrite)
+		if !ok {
 			return
 		}
+		authorId := info.UserId
+		log.Println(rid, "handleDeleteChirp for AuthorId", authorId)
 
 		chirpId, err := strconv.Atoi(r.PathValue("id"))
 		if err != nil {
@@ -139,23 +185,299 @@ func handleDeleteChirp(db *database.DB, jwtSecret []byte) http.Handler {
 		chirp, err := db.GetChirp(chirpId)
 		if err != nil {
 			respondWithError(w, 404, "Couldn't retrieve chirp", err)
+			return
 		}
 		if chirp.AuthorId != authorId {
 			respondWithError(w, 403, "User not authenticated to delete this chirp", nil)
+			return
+		}
+		// Replies keep pointing at the deleted chirp, which shows up as a tombstone in their thread
+		err = db.DeleteChirp(chirpId)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
 		}
+		refreshTrends(w, db, tracker)
 		w.WriteHeader(200)
 	})
 }
 
-func cleanBadWords(body string) string {
-	// Replace the following words with '****'
-	badWords := []string{"kerfuffle", "sharbert", "fornax"}
+func handleGetChirpReplies(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := auth.optional(r, scopeChirpsRead)
+		chirpId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given chirp ID is not a number", err)
+			return
+		}
+		replies, err := db.GetChirpReplies(chirpId)
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, 404, "Couldn't retrieve chirp", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		err = markLikedByMe(db, info, replies)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, replies)
+	})
+}
+
+const defaultThreadDepth = 5
+const maxThreadDepth = 10
+
+// Shows the whole conversation that a chirp belongs to, however deep into it the chirp is.
+func handleGetChirpThread(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := auth.optional(r, scopeChirpsRead)
+		chirpId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given chirp ID is not a number", err)
+			return
+		}
+		depth := defaultThreadDepth
+		if s := r.URL.Query().Get("depth"); s != "" {
+			depth, err = strconv.Atoi(s)
+			if err != nil || depth < 1 || depth > maxThreadDepth {
+				respondWithError(w, 400, fmt.Sprintf("depth must be a number between 1 and %d", maxThreadDepth), err)
+				return
+			}
+		}
+
+		thread, err := db.GetThread(chirpId, depth)
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, 404, "Couldn't retrieve chirp", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		err = markThreadLikedByMe(db, info, &thread)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, thread)
+	})
+}
+
+var errChirpTooLong = errors.New("Chirp is too long")
+
+// Returned when a chirp matches a reject rule of the moderation filter.
+type rejectedError struct {
+	matches []moderation.Match
+}
+
+func (e *rejectedError) Error() string {
+	return "Chirp violates the content rules"
+}
+
+// Checks and cleans up the body of a new or edited chirp. Besides the body with masked words replaced, returns the matches of rules that flag the chirp for review.
+func prepareChirpBody(filter *moderation.Filter, body string) (string, []moderation.Match, error) {
+	if l := len(body); l > 140 {
+		log.Printf("Received chirp with %d > 140 characters, rejected", l)
+		return "", nil, errChirpTooLong
+	}
+	// Chirp has valid length, proceed to clean it up
+	result := filter.Check(strings.TrimSpace(body))
+	if result.Rejected() {
+		return "", nil, &rejectedError{matches: result.MatchesOf(moderation.ActionReject)}
+	}
+	return result.Body, result.MatchesOf(moderation.ActionFlag), nil
+}
+
+// Rejected chirps get 422 along with the words that got them rejected, so that authors know what to change.
+func respondWithBodyError(w http.ResponseWriter, err error) {
+	var rerr *rejectedError
+	if !errors.As(err, &rerr) {
+		respondWithError(w, 400, err.Error(), err)
+		return
+	}
+	log.Println(getRequestID(w), "Rejecting chirp:", rerr.matches)
+	type response struct {
+		Error   string   `json:"error"`
+		Matches []string `json:"matches"`
+	}
+	resp := response{Error: rerr.Error(), Matches: []string{}}
+	for _, m := range rerr.matches {
+		resp.Matches = append(resp.Matches, m.Text)
+	}
+	respondWithJSON(w, 422, resp)
+}
 
-	words := strings.Split(body, " ")
-	for i, w := range words {
-		if slices.Contains(badWords, strings.ToLower(w)) {
-			words[i] = "****"
+// Records a chirp for review if any rules flagged it. The chirp has already been saved by then, so failures are only logged.
+func flagChirp(w http.ResponseWriter, db *database.DB, chirpId int, flags []moderation.Match) {
+	if len(flags) == 0 {
+		return
+	}
+	ruleIds, matches := []string{}, []string{}
+	for _, f := range flags {
+		if !slices.Contains(ruleIds, f.RuleId) {
+			ruleIds = append(ruleIds, f.RuleId)
 		}
+		matches = append(matches, f.Text)
 	}
-	return strings.Join(words, " ")
+	err := db.FlagChirp(chirpId, ruleIds, matches)
+	if err != nil {
+		log.Println(getRequestID(w), "Failed to flag chirp", chirpId, err)
+	}
+}
+
+// Finds the hashtags, mentions and URLs in a prepared chirp body.
+func extractEntities(db *database.DB, body string) ([]entities.Entity, error) {
+	return db.ResolveMentions(entities.Extract(body))
+}
+
+func handleGetHashtagChirps(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := auth.optional(r, scopeChirpsRead)
+		chirps, err := db.GetChirpsByHashtag(r.PathValue("tag"))
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		err = markLikedByMe(db, info, chirps)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, chirps)
+	})
+}
+
+func handleGetUserMentions(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := auth.optional(r, scopeChirpsRead)
+		userId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given user ID is not a number", err)
+			return
+		}
+		chirps, err := db.GetMentions(userId)
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, 404, "User not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		err = markLikedByMe(db, info, chirps)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, chirps)
+	})
+}
+
+// Lets authors correct their chirps for a while after posting them. Every earlier body is kept in the chirp's history.
+func handlePutChirp(db *database.DB, auth *authenticator, requireVerifiedEmail bool, editWindow time.Duration, tracker *trends.Tracker, filter *moderation.Filter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeChirpsWrite)
+		if !ok {
+			return
+		}
+		chirpId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given chirp ID is not a number", err)
+			return
+		}
+		log.Println(rid, "handlePutChirp", chirpId, "for AuthorId", info.UserId)
+		if !requireVerified(w, db, requireVerifiedEmail, info.UserId) {
+			return
+		}
+
+		type parameters struct {
+			Body string `json:"body"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err = decoder.Decode(&params)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request", err)
+			return
+		}
+
+		chirp, err := db.GetChirp(chirpId)
+		if err != nil {
+			respondWithError(w, 404, "Couldn't retrieve chirp", err)
+			return
+		}
+		if chirp.AuthorId != info.UserId {
+			respondWithError(w, 403, "User not authenticated to edit this chirp", nil)
+			return
+		}
+		if chirp.RechirpOf != 0 {
+			respondWithError(w, 400, "Rechirps have no body to edit", nil)
+			return
+		}
+		if time.Since(chirp.CreatedAt) > editWindow {
+			respondWithError(w, 403, fmt.Sprintf("Chirps can only be edited within %v of posting", editWindow), nil)
+			return
+		}
+
+		body, flags, err := prepareChirpBody(filter, params.Body)
+		if err != nil {
+			respondWithBodyError(w, err)
+			return
+		}
+		if body == chirp.Body {
+			respondWithJSON(w, 200, chirp)
+			return
+		}
+		ents, err := extractEntities(db, body)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		chirp, err = db.EditChirp(chirpId, body, ents)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		flagChirp(w, db, chirp.Id, flags)
+		refreshTrends(w, db, tracker)
+		respondWithJSON(w, 200, chirp)
+	})
+}
+
+func handleGetChirpHistory(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := auth.optional(r, scopeChirpsRead)
+		chirpId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given chirp ID is not a number", err)
+			return
+		}
+		chirp, err := db.GetChirp(chirpId)
+		if err != nil {
+			respondWithError(w, 404, "Couldn't retrieve chirp", err)
+			return
+		}
+		revisions, err := db.GetChirpRevisions(chirpId)
+		if err != nil {
+			respondWithError(w, 404, "Couldn't retrieve chirp", err)
+			return
+		}
+
+		err = markChirpLikedByMe(db, info, &chirp)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+
+		type response struct {
+			Chirp     Chirp                    `json:"chirp"`
+			Revisions []database.ChirpRevision `json:"revisions"`
+		}
+		respondWithJSON(w, 200, response{Chirp: chirp, Revisions: revisions})
+	})
 }
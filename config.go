@@ -0,0 +1,176 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/mailer"
+	"github.com/madsbv/go-server-exercise/internal/password"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Reads an integer setting from the environment, falling back to the default if it is unset.
+func getenvInt(key string, fallback int) int {
+	s := os.Getenv(key)
+	if s == "" {
+		return fallback
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		log.Fatalf("Invalid value %q for %s: %v", s, key, err)
+	}
+	return i
+}
+
+// Reads a boolean setting from the environment, falling back to the default if it is unset.
+func getenvBool(key string, fallback bool) bool {
+	s := os.Getenv(key)
+	if s == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		log.Fatalf("Invalid value %q for %s: %v", s, key, err)
+	}
+	return b
+}
+
+// Reads a duration setting such as "15m" from the environment, falling back to the default if it is unset.
+func getenvDuration(key string, fallback time.Duration) time.Duration {
+	s := os.Getenv(key)
+	if s == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Fatalf("Invalid value %q for %s: %v", s, key, err)
+	}
+	return d
+}
+
+func getenvString(key string, fallback string) string {
+	if s := os.Getenv(key); s != "" {
+		return s
+	}
+	return fallback
+}
+
+// MAILER selects how outgoing email is delivered: "outbox" (the default) writes messages to MAIL_OUTBOX_DIR, "smtp" sends them through SMTP_HOST.
+func mailerFromEnv() mailer.Mailer {
+	from := getenvString("MAIL_FROM", "chirpy@localhost")
+	switch m := os.Getenv("MAILER"); m {
+	case "", "outbox":
+		outbox, err := mailer.NewOutbox(getenvString("MAIL_OUTBOX_DIR", "outbox"), from)
+		if err != nil {
+			log.Fatal("Failed to create mail outbox: ", err)
+		}
+		return outbox
+	case "smtp":
+		return mailer.SMTP{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     getenvInt("SMTP_PORT", 587),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	default:
+		log.Fatalf("Unknown MAILER %q, expected outbox or smtp", m)
+		return nil
+	}
+}
+
+const registrationOpen = "open"
+const registrationInvite = "invite"
+const registrationClosed = "closed"
+
+// REGISTRATION_MODE controls who can sign up: anyone ("open", the default), only people with an invite code ("invite"), or nobody ("closed").
+func registrationModeFromEnv() string {
+	switch m := getenvString("REGISTRATION_MODE", registrationOpen); m {
+	case registrationOpen, registrationInvite, registrationClosed:
+		return m
+	default:
+		log.Fatalf("Unknown REGISTRATION_MODE %q, expected open, invite or closed", m)
+		return ""
+	}
+}
+
+// INTROSPECTION_CLIENTS lists the services allowed to introspect tokens, as comma separated id:secret pairs.
+func introspectionClientsFromEnv() map[string]string {
+	clients := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv("INTROSPECTION_CLIENTS"), ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || secret == "" {
+			log.Fatalf("Invalid entry %q in INTROSPECTION_CLIENTS, expected id:secret", pair)
+		}
+		clients[id] = secret
+	}
+	return clients
+}
+
+// PASSWORD_MIN_LENGTH defaults to 8. PASSWORD_BLOCKLIST_FILE optionally points to a list of breached or common passwords to reject.
+func passwordPolicyFromEnv() *password.Policy {
+	policy := password.NewPolicy(getenvInt("PASSWORD_MIN_LENGTH", 8))
+	if path := os.Getenv("PASSWORD_BLOCKLIST_FILE"); path != "" {
+		err := policy.LoadBreachedList(path)
+		if err != nil {
+			log.Fatalf("Failed to load password blocklist %s: %v", path, err)
+		}
+	}
+	return policy
+}
+
+// PASSWORD_HASHER selects the algorithm used for new hashes. Existing hashes made with the other algorithm, or with different parameters, are upgraded the next time their owner logs in.
+func passwordHasherFromEnv() *password.Scheme {
+	switch h := os.Getenv("PASSWORD_HASHER"); h {
+	case "bcrypt":
+		cost := getenvInt("BCRYPT_COST", password.DefaultBcryptCost)
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			log.Fatalf("BCRYPT_COST must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, cost)
+		}
+		return password.NewScheme(password.Bcrypt{Cost: cost})
+	case "", "argon2id":
+		// Out of range values would otherwise wrap around when converted, or make argon2 panic on the first hash
+		a := password.DefaultArgon2id
+		t := getenvInt("ARGON2_TIME", int(a.Time))
+		if t < 1 || t > math.MaxUint32 {
+			log.Fatalf("ARGON2_TIME must be between 1 and %d, got %d", uint32(math.MaxUint32), t)
+		}
+		threads := getenvInt("ARGON2_THREADS", int(a.Threads))
+		if threads < 1 || threads > math.MaxUint8 {
+			log.Fatalf("ARGON2_THREADS must be between 1 and %d, got %d", math.MaxUint8, threads)
+		}
+		// argon2 needs at least 8 KiB per thread
+		memory := getenvInt("ARGON2_MEMORY_KIB", int(a.Memory))
+		if memory < 8*threads || memory > math.MaxUint32 {
+			log.Fatalf("ARGON2_MEMORY_KIB must be between 8 times ARGON2_THREADS (%d) and %d, got %d", 8*threads, uint32(math.MaxUint32), memory)
+		}
+		a.Time = uint32(t)
+		a.Threads = uint8(threads)
+		a.Memory = uint32(memory)
+		return password.NewScheme(a)
+	default:
+		log.Fatalf("Unknown PASSWORD_HASHER %q, expected bcrypt or argon2id", h)
+		return nil
+	}
+}
+
+// TREND_WINDOWS lists the windows that hashtag trends are reported over, as comma separated durations. Defaults to "1h,24h".
+func trendWindowsFromEnv() []time.Duration {
+	windows := []time.Duration{}
+	for _, s := range strings.Split(getenvString("TREND_WINDOWS", "1h,24h"), ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid window %q in TREND_WINDOWS: %v", s, err)
+		}
+		windows = append(windows, d)
+	}
+	return windows
+}
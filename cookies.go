@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+// Browsers can log in with cookies instead of bearer tokens, so that the tokens are never accessible to JavaScript.
+const accessCookieName = "chirpy_access"
+const refreshCookieName = "chirpy_refresh"
+
+// The CSRF cookie is readable by JavaScript on purpose. The app sends its value back in the CSRF header, which other sites can't do.
+const csrfCookieName = "chirpy_csrf"
+const csrfHeaderName = "X-CSRF-Token"
+
+// The refresh cookie is only sent to the browser session routes
+const refreshCookiePath = "/api/browser"
+
+var errInvalidCSRFToken = errors.New("Missing or invalid CSRF token")
+
+// CSRF tokens are an HMAC of the session ID, so they don't need to be stored and are useless for any other session.
+func (a *authenticator) csrfToken(sessionId string) string {
+	mac := hmac.New(sha256.New, a.jwtSecret)
+	mac.Write([]byte("csrf:" + sessionId))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *authenticator) checkCSRF(r *http.Request, sessionId string) error {
+	if sessionId == "" {
+		return errInvalidCSRFToken
+	}
+	token := r.Header.Get(csrfHeaderName)
+	if !hmac.Equal([]byte(token), []byte(a.csrfToken(sessionId))) {
+		return errInvalidCSRFToken
+	}
+	return nil
+}
+
+// Requests that only read data don't need CSRF protection.
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Answers a completed login, either with the tokens in the body or by setting them as cookies.
+type loginResponder func(w http.ResponseWriter, resp loginResponse)
+
+func respondWithTokens(w http.ResponseWriter, resp loginResponse) {
+	respondWithJSON(w, 200, resp)
+}
+
+// Sets the tokens as cookies, and responds with the user and the CSRF token the app has to send along with requests that change anything.
+func respondWithCookies(auth *authenticator, secure bool) loginResponder {
+	return func(w http.ResponseWriter, resp loginResponse) {
+		csrf := auth.csrfToken(resp.SessionId)
+		setAccessCookie(w, resp.Token, secure)
+		http.SetCookie(w, &http.Cookie{
+			Name:     refreshCookieName,
+			Value:    resp.RefreshToken,
+			Path:     refreshCookiePath,
+			MaxAge:   expirationRefreshSeconds,
+			HttpOnly: true,
+			Secure:   secure,
+			SameSite: http.SameSiteStrictMode,
+		})
+		http.SetCookie(w, &http.Cookie{
+			Name:     csrfCookieName,
+			Value:    csrf,
+			Path:     "/",
+			MaxAge:   expirationRefreshSeconds,
+			Secure:   secure,
+			SameSite: http.SameSiteStrictMode,
+		})
+
+		type response struct {
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"email_verified"`
+			Id            int    `json:"id"`
+			IsChirpyRed   bool   `json:"is_chirpy_red"`
+			CSRFToken     string `json:"csrf_token"`
+		}
+		respondWithJSON(w, 200, response{Email: resp.Email, EmailVerified: resp.EmailVerified, Id: resp.Id, IsChirpyRed: resp.IsChirpyRed, CSRFToken: csrf})
+	}
+}
+
+func setAccessCookie(w http.ResponseWriter, token string, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   expirationAccessSeconds,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookies(w http.ResponseWriter, secure bool) {
+	for _, c := range []struct{ name, path string }{
+		{accessCookieName, "/"},
+		{refreshCookieName, refreshCookiePath},
+		{csrfCookieName, "/"},
+	} {
+		http.SetCookie(w, &http.Cookie{Name: c.name, Path: c.path, MaxAge: -1, HttpOnly: c.name != csrfCookieName, Secure: secure})
+	}
+}
+
+// Resolves the session of a browser from its cookies, checking the CSRF token. The access cookie is preferred, but the refresh cookie still works once the access token has expired.
+func (a *authenticator) browserSession(r *http.Request) (access, refresh authInfo, err error) {
+	if c, err := r.Cookie(accessCookieName); err == nil {
+		access, _ = a.inspect(c.Value, false)
+		if access.TokenType != tokenTypeAccess {
+			access = authInfo{}
+		}
+	}
+	if c, err := r.Cookie(refreshCookieName); err == nil {
+		refresh, _ = a.inspect(c.Value, false)
+		if refresh.TokenType != tokenTypeRefresh {
+			refresh = authInfo{}
+		}
+	}
+	sessionId := access.SessionId
+	if sessionId == "" {
+		sessionId = refresh.SessionId
+	}
+	if sessionId == "" {
+		return authInfo{}, authInfo{}, errors.New("No valid session cookie")
+	}
+	err = a.checkCSRF(r, sessionId)
+	if err != nil {
+		return authInfo{}, authInfo{}, err
+	}
+	return access, refresh, nil
+}
+
+// Issues a new access cookie from the refresh cookie.
+func handlePostBrowserRefresh(db *database.DB, auth *authenticator, secure bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		_, refresh, err := auth.browserSession(r)
+		if err == nil && refresh.SessionId == "" {
+			err = errors.New("No valid refresh cookie")
+		}
+		if err != nil {
+			respondWithError(w, 401, "Authentication failed", err)
+			return
+		}
+		log.Println(rid, "handlePostBrowserRefresh for user", refresh.UserId)
+
+		c, _ := r.Cookie(refreshCookieName)
+		claims, err := validateToken(c.Value, refreshIssuer, auth.jwtSecret)
+		if err != nil {
+			respondWithError(w, 401, "Invalid token", err)
+			return
+		}
+		_, err = db.UseRefreshToken(c.Value, clientIP(r), r.UserAgent())
+		if err != nil {
+			respondWithError(w, 401, "Session not found", err)
+			return
+		}
+		token, err := refreshAccessToken(*claims, auth.jwtSecret)
+		if err != nil {
+			respondWithError(w, 500, "Error creating access token", err)
+			return
+		}
+		setAccessCookie(w, token, secure)
+		w.WriteHeader(200)
+	})
+}
+
+// Ends the browser session: the access token is denylisted, the session revoked and the cookies cleared.
+func handlePostBrowserLogout(db *database.DB, auth *authenticator, secure bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		access, refresh, err := auth.browserSession(r)
+		if errors.Is(err, errInvalidCSRFToken) {
+			respondWithError(w, 403, "Missing or invalid CSRF token", err)
+			return
+		}
+		// Without a valid session there is nothing to revoke, but the cookies should still go
+		if err == nil {
+			if access.TokenId != "" {
+				err = auth.denylist.deny(access.TokenId, access.ExpiresAt)
+				if err != nil {
+					respondWithError(w, 500, "Potential database error", err)
+					return
+				}
+			}
+			userId, sessionId := access.UserId, access.SessionId
+			if sessionId == "" {
+				userId, sessionId = refresh.UserId, refresh.SessionId
+			}
+			err = db.RevokeSession(userId, sessionId)
+			if err != nil && !errors.Is(err, database.ErrSessionNotFound) {
+				respondWithError(w, 500, "Potential database error", err)
+				return
+			}
+			log.Println(rid, "Logged out browser session", sessionId, "of user", userId)
+		}
+		clearSessionCookies(w, secure)
+		w.WriteHeader(200)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+// Keeps the IDs of revoked access tokens in memory, since they are checked on every authenticated request, and persists them to the database so they survive restarts.
+type tokenDenylist struct {
+	db      *database.DB
+	entries map[string]time.Time
+	mux     sync.RWMutex
+}
+
+func newTokenDenylist(db *database.DB) (*tokenDenylist, error) {
+	entries, err := db.GetDeniedTokens()
+	if err != nil {
+		return nil, err
+	}
+	return &tokenDenylist{db: db, entries: entries}, nil
+}
+
+// Denylists the token until it expires.
+func (d *tokenDenylist) deny(tokenId string, expiresAt time.Time) error {
+	d.mux.Lock()
+	d.entries[tokenId] = expiresAt
+	d.mux.Unlock()
+	return d.db.DenyToken(tokenId, expiresAt)
+}
+
+func (d *tokenDenylist) denied(tokenId string) bool {
+	d.mux.RLock()
+	defer d.mux.RUnlock()
+	_, ok := d.entries[tokenId]
+	return ok
+}
+
+// Drops entries for tokens that have expired, both in memory and in the database.
+func (d *tokenDenylist) cleanup() {
+	now := time.Now()
+	d.mux.Lock()
+	for id, expiresAt := range d.entries {
+		if now.After(expiresAt) {
+			delete(d.entries, id)
+		}
+	}
+	d.mux.Unlock()
+
+	pruned, err := d.db.PruneDeniedTokens(now)
+	if err != nil {
+		log.Println("Error pruning denied tokens:", err)
+		return
+	}
+	if pruned > 0 {
+		log.Println("Pruned", pruned, "expired tokens from the denylist")
+	}
+}
+
+func (d *tokenDenylist) runCleanup(interval time.Duration) {
+	for range time.Tick(interval) {
+		d.cleanup()
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+const expirationDeviceCodeSeconds = 60 * 10 // 10 minutes
+const devicePollInterval = 5 * time.Second
+const grantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Consonants only, so that user codes are easy to type and can't spell words, see RFC 8628 section 6.1
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+const userCodeLength = 8
+
+// Starts the device authorization grant from RFC 8628. The device shows the user code and verification URI to the user, and polls the token endpoint with the device code until the user has approved it.
+func handlePostDeviceAuthorization(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		w.Header().Set("Cache-Control", "no-store")
+
+		client, ok := authenticateClient(w, r, db)
+		if !ok {
+			return
+		}
+		scopes, err := parseRequestedScopes(r.PostFormValue("scope"), client)
+		var oerr *oauthError
+		if errors.As(err, &oerr) {
+			respondWithOAuthError(w, 400, oerr)
+			return
+		}
+		log.Println(rid, "handlePostDeviceAuthorization for client", client.Id, scopes)
+
+		deviceCode, err := newRandomToken()
+		if err != nil {
+			respondWithError(w, 500, "Error generating device code", err)
+			return
+		}
+		userCode, err := newUserCode()
+		if err != nil {
+			respondWithError(w, 500, "Error generating user code", err)
+			return
+		}
+		now := time.Now()
+		err = db.CreateDeviceAuthorization(hashToken(deviceCode), database.DeviceAuthorization{
+			ClientId:     client.Id,
+			Scopes:       scopes,
+			UserCodeHash: hashToken(normalizeUserCode(userCode)),
+			Interval:     devicePollInterval,
+			ExpiresAt:    now.Add(expirationDeviceCodeSeconds * time.Second),
+		})
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+
+		type response struct {
+			DeviceCode              string `json:"device_code"`
+			UserCode                string `json:"user_code"`
+			VerificationURI         string `json:"verification_uri"`
+			VerificationURIComplete string `json:"verification_uri_complete"`
+			ExpiresIn               int    `json:"expires_in"`
+			Interval                int    `json:"interval"`
+		}
+		verificationURI := baseURL(r) + "/device"
+		respondWithJSON(w, 200, response{
+			DeviceCode:              deviceCode,
+			UserCode:                userCode,
+			VerificationURI:         verificationURI,
+			VerificationURIComplete: verificationURI + "?user_code=" + userCode,
+			ExpiresIn:               expirationDeviceCodeSeconds,
+			Interval:                int(devicePollInterval.Seconds()),
+		})
+	})
+}
+
+func handleGetDevice(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userCode := r.URL.Query().Get("user_code")
+		page := devicePageData{UserCode: userCode}
+		if userCode != "" {
+			auth, err := db.GetDeviceAuthorization(hashToken(normalizeUserCode(userCode)))
+			if err == nil {
+				page.describe(db, auth)
+			}
+		}
+		renderPage(w, 200, devicePage, page)
+	})
+}
+
+// Handles the approval form. Denying a device needs only the code, approving it needs a full login.
+func handlePostDevice(db *database.DB, throttle *loginThrottle) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		userCode := r.PostFormValue("user_code")
+		page := devicePageData{UserCode: userCode, Email: r.PostFormValue("email")}
+
+		auth, status, msg := lookupUserCode(w, r, db, throttle, userCode)
+		if status != 0 {
+			page.Error = msg
+			renderPage(w, status, devicePage, page)
+			return
+		}
+		page.describe(db, auth)
+
+		if r.PostFormValue("decision") != "allow" {
+			_, err := db.ResolveDeviceAuthorization(hashToken(normalizeUserCode(userCode)), 0, false)
+			if err != nil {
+				respondWithError(w, 500, "Potential database error", err)
+				return
+			}
+			log.Println(rid, "Denied device authorization for client", auth.ClientId)
+			page.Done = "The device was denied access. You can close this page."
+			renderPage(w, 200, devicePage, page)
+			return
+		}
+
+		user, status, msg := checkFormLogin(w, r, db, throttle)
+		if status != 0 {
+			page.Error = msg
+			renderPage(w, status, devicePage, page)
+			return
+		}
+		_, err := db.ResolveDeviceAuthorization(hashToken(normalizeUserCode(userCode)), user.Id, true)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		log.Println(rid, "User", user.Id, "approved device authorization for client", auth.ClientId)
+		page.Done = "The device is now signed in. You can return to it and close this page."
+		renderPage(w, 200, devicePage, page)
+	})
+}
+
+// Lets users who are already signed in to an app approve a device without typing their password again.
+func handlePostDeviceApprove(db *database.DB, auth *authenticator, throttle *loginThrottle) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		type parameters struct {
+			UserCode string `json:"user_code"`
+			Approve  bool   `json:"approve"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		log.Println(rid, "handlePostDeviceApprove for user", info.UserId, params.Approve)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		_, status, msg := lookupUserCode(w, r, db, throttle, params.UserCode)
+		if status != 0 {
+			respondWithError(w, status, msg, nil)
+			return
+		}
+		device, err := db.ResolveDeviceAuthorization(hashToken(normalizeUserCode(params.UserCode)), info.UserId, params.Approve)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+
+		type response struct {
+			ClientId string   `json:"client_id"`
+			Scopes   []string `json:"scopes"`
+			Status   string   `json:"status"`
+		}
+		respondWithJSON(w, 200, response{ClientId: device.ClientId, Scopes: device.Scopes, Status: device.Status})
+	})
+}
+
+// Finds the pending authorization for a user code. Unknown codes count against the client IP, so that codes can't be guessed.
+func lookupUserCode(w http.ResponseWriter, r *http.Request, db *database.DB, throttle *loginThrottle, userCode string) (database.DeviceAuthorization, int, string) {
+	rid := getRequestID(w)
+	ipKey := ipThrottleKey(clientIP(r))
+	attempt, wait := throttle.reserve(ipKey)
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		return database.DeviceAuthorization{}, 429, "Too many failed attempts, try again later"
+	}
+	auth, err := db.GetDeviceAuthorization(hashToken(normalizeUserCode(userCode)))
+	if errors.Is(err, database.ErrDeviceCodeNotFound) {
+		recordFailedLogin(rid, db, attempt)
+		return database.DeviceAuthorization{}, 400, "Unknown or expired code"
+	}
+	attempt.release()
+	if err != nil {
+		log.Println(rid, "Error looking up user code", err)
+		return database.DeviceAuthorization{}, 500, "Potential database error"
+	}
+	return auth, 0, ""
+}
+
+func exchangeDeviceCode(db *database.DB, jwtSecret []byte, client database.OAuthClient, r *http.Request) (tokenResponse, error) {
+	device, err := db.PollDeviceAuthorization(hashToken(r.PostFormValue("device_code")), client.Id)
+	switch {
+	case errors.Is(err, database.ErrAuthorizationPending):
+		return tokenResponse{}, &oauthError{"authorization_pending", "The user hasn't approved the device yet"}
+	case errors.Is(err, database.ErrSlowDown):
+		return tokenResponse{}, &oauthError{"slow_down", "Polling too fast, increase the interval by 5 seconds"}
+	case errors.Is(err, database.ErrDeviceAccessDenied):
+		return tokenResponse{}, &oauthError{"access_denied", "The user denied the device"}
+	case errors.Is(err, database.ErrDeviceCodeExpired):
+		return tokenResponse{}, &oauthError{"expired_token", "Device code has expired, start over"}
+	case errors.Is(err, database.ErrDeviceCodeNotFound):
+		return tokenResponse{}, &oauthError{"invalid_grant", "Device code is invalid"}
+	case err != nil:
+		return tokenResponse{}, err
+	}
+
+	scope := strings.Join(device.Scopes, " ")
+	access, refresh, err := issueSessionTokens(db, device.UserId, &tokenClaims{Scope: scope, ClientId: client.Id}, expirationAccessSeconds, jwtSecret, r)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	return tokenResponse{AccessToken: access, TokenType: "Bearer", ExpiresIn: expirationAccessSeconds, RefreshToken: refresh, Scope: scope}, nil
+}
+
+// Returns a user code of the form XXXX-XXXX.
+func newUserCode() (string, error) {
+	var sb strings.Builder
+	for i := range userCodeLength {
+		if i == userCodeLength/2 {
+			sb.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// Users may type user codes in lower case, or without the dash.
+func normalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(userCodeAlphabet, r) {
+			return r
+		}
+		return -1
+	}, strings.ToUpper(code))
+}
+
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+type devicePageData struct {
+	UserCode   string
+	ClientName string
+	Scopes     []string
+	Email      string
+	Error      string
+	// Set once the device has been approved or denied
+	Done string
+}
+
+// Fills in what the device is asking for, so that users can tell what they are approving.
+func (p *devicePageData) describe(db *database.DB, auth database.DeviceAuthorization) {
+	client, err := db.GetOAuthClient(auth.ClientId)
+	if err != nil {
+		return
+	}
+	p.ClientName = client.Name
+	p.Scopes = make([]string, len(auth.Scopes))
+	for i, s := range auth.Scopes {
+		p.Scopes[i] = scopeDescriptions[s]
+	}
+}
+
+var devicePage = template.Must(template.New("device").Parse(`<html>
+  <head>
+    <title>Sign in a device</title>
+  </head>
+  <body>
+    <h1>Sign in a device</h1>
+    {{- if .Done}}
+    <p>{{.Done}}</p>
+    {{- else}}
+    {{- if .ClientName}}
+    <p>{{.ClientName}} would like to:</p>
+    <ul>
+      {{- range .Scopes}}
+      <li>{{.}}</li>
+      {{- end}}
+    </ul>
+    {{- else}}
+    <p>Enter the code shown on your device.</p>
+    {{- end}}
+    {{- if .Error}}
+    <p><strong>{{.Error}}</strong></p>
+    {{- end}}
+    <form method="post" action="/device">
+      <p><label>Code <input type="text" name="user_code" value="{{.UserCode}}" autocomplete="off" required></label></p>
+      <p><label>Email <input type="email" name="email" value="{{.Email}}" required></label></p>
+      <p><label>Password <input type="password" name="password" required></label></p>
+      <p><label>Two-factor or recovery code, if enabled <input type="text" name="code" autocomplete="one-time-code"></label></p>
+      <button type="submit" name="decision" value="allow">Allow</button>
+      <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+    </form>
+    {{- end}}
+  </body>
+</html>
+`))
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+const defaultTimelineLimit = 20
+const maxTimelineLimit = 100
+
+func handlePutFollow(db *database.DB, auth *authenticator) http.Handler {
+	return handleFollow(db, auth, true)
+}
+
+func handleDeleteFollow(db *database.DB, auth *authenticator) http.Handler {
+	return handleFollow(db, auth, false)
+}
+
+// Following and unfollowing are both idempotent. Responds with the profile of the followed user, so that clients can update its counts.
+func handleFollow(db *database.DB, auth *authenticator, follow bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeProfileWrite)
+		if !ok {
+			return
+		}
+		followeeId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given user ID is not a number", err)
+			return
+		}
+		log.Println(rid, "handleFollow", followeeId, follow, "for user", info.UserId)
+
+		setFollow := db.Unfollow
+		if follow {
+			setFollow = db.Follow
+		}
+		err = setFollow(info.UserId, followeeId)
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, 404, "User not found", err)
+			return
+		}
+		if errors.Is(err, database.ErrCannotFollowSelf) {
+			respondWithError(w, 400, err.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+
+		profile, err := db.GetUserProfile(followeeId)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, profile)
+	})
+}
+
+func handleGetFollowers(db *database.DB) http.Handler {
+	return handleGetFollowList(db.GetFollowers)
+}
+
+func handleGetFollowing(db *database.DB) http.Handler {
+	return handleGetFollowList(db.GetFollowing)
+}
+
+func handleGetFollowList(list func(id int) ([]database.SafeUser, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given user ID is not a number", err)
+			return
+		}
+		users, err := list(id)
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, 404, "User not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, users)
+	})
+}
+
+// The user's own chirps and those of everyone they follow, newest first. Clients page through it by passing back next_cursor, which is left out on the last page.
+func handleGetTimeline(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := auth.require(w, r, scopeChirpsRead)
+		if !ok {
+			return
+		}
+		query := r.URL.Query()
+		limit := defaultTimelineLimit
+		if s := query.Get("limit"); s != "" {
+			l, err := strconv.Atoi(s)
+			if err != nil || l < 1 || l > maxTimelineLimit {
+				respondWithError(w, 400, fmt.Sprintf("limit must be a number between 1 and %d", maxTimelineLimit), err)
+				return
+			}
+			limit = l
+		}
+		before := 0
+		if s := query.Get("cursor"); s != "" {
+			c, err := strconv.Atoi(s)
+			if err != nil || c < 1 {
+				respondWithError(w, 400, "Invalid cursor", err)
+				return
+			}
+			before = c
+		}
+
+		chirps, next, err := db.GetTimeline(info.UserId, before, limit)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		err = markLikedByMe(db, info, chirps)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+
+		type response struct {
+			Chirps     []Chirp `json:"chirps"`
+			NextCursor string  `json:"next_cursor,omitempty"`
+		}
+		resp := response{Chirps: chirps}
+		if next != 0 {
+			resp.NextCursor = strconv.Itoa(next)
+		}
+		respondWithJSON(w, 200, resp)
+	})
+}
@@ -3,5 +3,11 @@ module github.com/madsbv/go-server-exercise
 go 1.22.1
 
 require golang.org/x/crypto v0.22.0
+
 require github.com/joho/godotenv v1.5.1
+
 require github.com/golang-jwt/jwt/v5 v5.2.1
+
+require golang.org/x/text v0.14.0
+
+require golang.org/x/sys v0.19.0 // indirect
@@ -7,45 +7,134 @@ import (
 	"log"
 	"os"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/madsbv/go-server-exercise/internal/entities"
+	"github.com/madsbv/go-server-exercise/internal/password"
 )
 
 type Chirp struct {
-	Body     string `json:"body"`
-	Id       int    `json:"id"`
-	AuthorId int    `json:"author_id"`
+	Body      string     `json:"body"`
+	Id        int        `json:"id"`
+	AuthorId  int        `json:"author_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	// Number of earlier bodies kept in the chirp's history
+	RevisionCount int `json:"revision_count"`
+	// The chirp this one replies to, if any
+	InReplyTo  int `json:"in_reply_to,omitempty"`
+	ReplyCount int `json:"reply_count"`
+	LikeCount  int `json:"like_count"`
+	// Only set when the request is authenticated, as it depends on who is asking
+	LikedByMe *bool `json:"liked_by_me,omitempty"`
+	// Rechirps repost another chirp as is and have no body of their own, quotes add a body to it
+	RechirpOf    int `json:"rechirp_of,omitempty"`
+	QuoteOf      int `json:"quote_of,omitempty"`
+	RechirpCount int `json:"rechirp_count"`
+	// The chirp that is rechirped or quoted, or a tombstone if it is gone
+	Embedded *Chirp            `json:"embedded_chirp,omitempty"`
+	Entities []entities.Entity `json:"entities"`
+	MediaIds []string          `json:"media_ids,omitempty"`
+	// The attached media in the order of MediaIds
+	Media []Media `json:"media,omitempty"`
+	// Deleted chirps are kept so that replies to them still have a place in their thread
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Set on stand-ins for chirps that were deleted or are hidden, which carry no content
+	Tombstone bool `json:"tombstone,omitempty"`
 }
 
 type user struct {
-	Email       string `json:"email"`
-	Hash        []byte `json:"hash"`
-	Id          int    `json:"id"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Hash          []byte `json:"hash"`
+	Id            int    `json:"id"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+	TOTP          TOTP   `json:"totp"`
+	// Incremented to invalidate every token issued to the user so far
+	TokenGeneration int `json:"token_generation"`
+	// The invite the user signed up with, if any
+	InviteId    int       `json:"invite_id,omitempty"`
+	Sanction    *Sanction `json:"sanction,omitempty"`
+	IsModerator bool      `json:"is_moderator,omitempty"`
 }
 
 type SafeUser struct {
-	Email       string `json:"email"`
-	Id          int    `json:"id"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Id            int    `json:"id"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
 }
 
 func (u user) clean() SafeUser {
-	return SafeUser{Email: u.Email, Id: u.Id, IsChirpyRed: u.IsChirpyRed}
+	return SafeUser{Email: u.Email, EmailVerified: u.EmailVerified, Id: u.Id, IsChirpyRed: u.IsChirpyRed}
 }
 
 type DB struct {
-	path string
-	mux  *sync.RWMutex
+	path   string
+	mux    *sync.RWMutex
+	hasher *password.Scheme
+	// Compared against when the email is unknown, so that response times don't reveal whether an account exists
+	dummyHash []byte
 }
 type DBStructure struct {
-	Chirps        map[int]Chirp        `json:"chirps"`
-	Users         map[int]user         `json:"users"`
-	RevokedTokens map[string]time.Time `json:"revoked_tokens"`
+	Chirps               map[int]Chirp                  `json:"chirps"`
+	Users                map[int]user                   `json:"users"`
+	RevokedTokens        map[string]time.Time           `json:"revoked_tokens"`
+	LockoutEvents        []LockoutEvent                 `json:"lockout_events"`
+	RefreshTokens        map[string]RefreshToken        `json:"refresh_tokens"`
+	PasswordResets       map[string]PasswordReset       `json:"password_resets"`
+	PersonalAccessTokens map[int]personalAccessToken    `json:"personal_access_tokens"`
+	DeniedTokens         map[string]time.Time           `json:"denied_tokens"`
+	OAuthClients         map[string]oauthClient         `json:"oauth_clients"`
+	AuthorizationCodes   map[string]AuthorizationCode   `json:"authorization_codes"`
+	DeviceAuthorizations map[string]DeviceAuthorization `json:"device_authorizations"`
+	Invites              map[int]invite                 `json:"invites"`
+	ChirpRevisions       map[int][]ChirpRevision        `json:"chirp_revisions"`
+	// Chirp id to the ids of the users who like it, and when they did
+	Likes map[int]map[int]time.Time `json:"likes"`
+	// Follower id to the ids of the users they follow, and since when
+	Follows map[int]map[int]time.Time `json:"follows"`
+	Media   map[string]Media          `json:"media"`
+	// Chirp id to why the moderation filter flagged it
+	FlaggedChirps map[int]FlaggedChirp `json:"flagged_chirps"`
+	Reports       map[int]Report       `json:"reports"`
+	ModerationLog []ModerationAction   `json:"moderation_log"`
 	// Cheap way to get unique ids
-	NextChirpId int `json:"nextChirpId"`
+	NextChirpId               int `json:"nextChirpId"`
+	NextPersonalAccessTokenId int `json:"next_personal_access_token_id"`
+	NextInviteId              int `json:"next_invite_id"`
+	NextReportId              int `json:"next_report_id"`
+}
+
+// Database files written by older versions may be missing newer tables, so loading starts from an empty structure rather than a zero value.
+func newDBStructure() DBStructure {
+	return DBStructure{
+		Chirps:                    make(map[int]Chirp),
+		Users:                     make(map[int]user),
+		RevokedTokens:             make(map[string]time.Time),
+		LockoutEvents:             []LockoutEvent{},
+		RefreshTokens:             make(map[string]RefreshToken),
+		PasswordResets:            make(map[string]PasswordReset),
+		PersonalAccessTokens:      make(map[int]personalAccessToken),
+		DeniedTokens:              make(map[string]time.Time),
+		OAuthClients:              make(map[string]oauthClient),
+		AuthorizationCodes:        make(map[string]AuthorizationCode),
+		DeviceAuthorizations:      make(map[string]DeviceAuthorization),
+		Invites:                   make(map[int]invite),
+		ChirpRevisions:            make(map[int][]ChirpRevision),
+		Likes:                     make(map[int]map[int]time.Time),
+		Follows:                   make(map[int]map[int]time.Time),
+		Media:                     make(map[string]Media),
+		FlaggedChirps:             make(map[int]FlaggedChirp),
+		Reports:                   make(map[int]Report),
+		ModerationLog:             []ModerationAction{},
+		NextChirpId:               1,
+		NextPersonalAccessTokenId: 1,
+		NextInviteId:              1,
+		NextReportId:              1,
+	}
 }
 
 func (db *DB) writeUser(user user, password string, newUser bool) (SafeUser, error) {
@@ -56,64 +145,103 @@ func (db *DB) writeUser(user user, password string, newUser bool) (SafeUser, err
 		log.Fatal("Invalid operation: Tried to specify the id when creating new user:", user)
 	}
 
-	dbs, err := db.load()
+	// Hashing is slow on purpose, so it happens before taking the lock
+	hash, err := db.hasher.Hash(password)
 	if err != nil {
+		log.Printf("Error hashing password when writing user: %v", user)
 		return user.clean(), err
 	}
-	if newUser {
-		user.Id = (len(dbs.Users) + 1)
-	}
-
-	// NOTE: This should be valid as long as we only modify the database here, but might need to be made more robust later if we start deleting users
 
-	user.Hash, err = bcrypt.GenerateFromPassword([]byte(password), 0)
+	err = db.update(func(dbs *DBStructure) error {
+		user.Hash = hash
+		if newUser {
+			return dbs.addUser(&user)
+		}
+		dbs.Users[user.Id] = user
+		return nil
+	})
 	if err != nil {
-		log.Printf("Error hashing password when writing user: %v", user)
+		log.Printf("Error writing database when writing user: %v", user)
 	}
+	return user.clean(), err
+}
 
-	dbs.Users[user.Id] = user
+var ErrEmailTaken = errors.New("Email address is already used by another account")
 
-	err = db.write(dbs)
-	if err != nil {
-		log.Printf("Error writing database when writing user: %v", user)
+// Fills in the id of the new user and stores it, unless its email is taken.
+func (dbs *DBStructure) addUser(u *user) error {
+	if dbs.emailTaken(u.Email, 0) {
+		return ErrEmailTaken
 	}
-	return user.clean(), err
+	// NOTE: This should be valid as long as we never delete users
+	u.Id = len(dbs.Users) + 1
+	dbs.Users[u.Id] = *u
+	return nil
 }
 
 func (db *DB) CreateUser(email, password string) (SafeUser, error) {
-	_, err := db.getUserByEmail(email)
-	if err == nil {
-		return SafeUser{}, errors.New("User with given email already exists")
-	}
 	user := user{Email: email}
 	return db.writeUser(user, password, true)
 }
 
 func (db *DB) UpdateUser(id int, email, password string) (SafeUser, error) {
-	suser, err := db.GetUser(id)
-	if err == nil {
-		return SafeUser{}, errors.New("User with given id not found")
+	hash, err := db.hasher.Hash(password)
+	if err != nil {
+		return SafeUser{}, err
 	}
-	user := user{Email: email, Id: id, IsChirpyRed: suser.IsChirpyRed}
-	return db.writeUser(user, password, false)
+	var updated user
+	err = db.update(func(dbs *DBStructure) error {
+		user, exists := dbs.Users[id]
+		if !exists {
+			return errors.New("User with given id not found")
+		}
+		if dbs.emailTaken(email, id) {
+			return ErrEmailTaken
+		}
+		// A new address has to be verified all over again
+		if user.Email != email {
+			user.EmailVerified = false
+		}
+		user.Email = email
+		user.Hash = hash
+		dbs.Users[id] = user
+		updated = user
+		return nil
+	})
+	return updated.clean(), err
 }
 
-func (db *DB) UpgradeUser(id int) error {
-	dbs, err := db.load()
-	if err != nil {
-		return err
-	}
+// Marks the user's email as verified, provided that it is still the address the verification was sent to.
+func (db *DB) VerifyEmail(id int, email string) (SafeUser, error) {
+	var verified user
+	err := db.update(func(dbs *DBStructure) error {
+		user, exists := dbs.Users[id]
+		if !exists {
+			return errors.New("User with given id not found")
+		}
+		if user.Email != email {
+			return errors.New("Email address has changed since verification was requested")
+		}
+		user.EmailVerified = true
+		dbs.Users[id] = user
+		verified = user
+		return nil
+	})
+	return verified.clean(), err
+}
 
-	user, exists := dbs.Users[id]
-	if !exists {
-		return fmt.Errorf("User doesn't exist")
-	}
+func (db *DB) UpgradeUser(id int) error {
+	return db.update(func(dbs *DBStructure) error {
+		user, exists := dbs.Users[id]
+		if !exists {
+			return fmt.Errorf("User doesn't exist")
+		}
 
-	user.IsChirpyRed = true
-	// NOTE: You can't update map values, only reassign them. So either we rewrite entries every time, or use maps of pointers.
-	dbs.Users[id] = user
-	db.write(dbs)
-	return nil
+		user.IsChirpyRed = true
+		// NOTE: You can't update map values, only reassign them. So either we rewrite entries every time, or use maps of pointers.
+		dbs.Users[id] = user
+		return nil
+	})
 }
 
 func (db *DB) GetSortedUsers() ([]SafeUser, error) {
@@ -150,54 +278,136 @@ func (db *DB) GetUser(id int) (SafeUser, error) {
 	return user.clean(), nil
 }
 
+func (db *DB) GetUserByEmail(email string) (SafeUser, error) {
+	user, err := db.getUserByEmail(email)
+	return user.clean(), err
+}
+
 func (db *DB) getUserByEmail(email string) (user, error) {
 	dbs, err := db.load()
 	if err != nil {
 		return user{}, err
 	}
+	u, exists := dbs.userByEmail(email)
+	if !exists {
+		return user{}, errors.New("User with requested email doesn't exist")
+	}
+	return u, nil
+}
+
+// Reports whether a user other than the given one has the address. Addresses that differ only in case reach the same mailbox, so they count as the same.
+func (dbs *DBStructure) emailTaken(email string, exceptId int) bool {
+	for id, u := range dbs.Users {
+		if id != exceptId && strings.EqualFold(u.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func (dbs *DBStructure) userByEmail(email string) (user, bool) {
 	for _, v := range dbs.Users {
 		if v.Email == email {
-			return v, nil
+			return v, true
 		}
 	}
-	return user{}, errors.New("User with requested email doesn't exist")
+	return user{}, false
 }
 
 func (db *DB) ValidateLogin(email, password string) (SafeUser, error) {
 	safeUser := SafeUser{}
 	user, err := db.getUserByEmail(email)
 	if err != nil {
+		db.hasher.Verify(db.dummyHash, password)
 		return safeUser, errors.New("User email not found")
 	}
 
-	err = bcrypt.CompareHashAndPassword(user.Hash, []byte(password))
-	if err == nil {
-		safeUser = user.clean()
+	needsRehash, err := db.hasher.Verify(user.Hash, password)
+	if err != nil {
+		return safeUser, err
 	}
-	return safeUser, err
+	if needsRehash {
+		// The login itself succeeded, so failing to upgrade the hash is not fatal. We'll try again next time.
+		err = db.rehashPassword(user.Id, password)
+		if err != nil {
+			log.Printf("Error upgrading password hash for user %d: %v", user.Id, err)
+		}
+	}
+	return user.clean(), nil
 }
 
-func (db *DB) CreateChirp(body string, authorId int) (Chirp, error) {
-	chirp := Chirp{}
+// Checks the password of a user who is already authenticated, before letting them change their credentials.
+func (db *DB) CheckPassword(id int, password string) error {
 	dbs, err := db.load()
 	if err != nil {
-		return chirp, err
+		return err
 	}
+	user, exists := dbs.Users[id]
+	if !exists {
+		return ErrUserNotFound
+	}
+	_, err = db.hasher.Verify(user.Hash, password)
+	return err
+}
 
-	id := dbs.NextChirpId
-	dbs.NextChirpId++
+// Replaces the stored hash with one from the preferred hasher, after the password has been verified against the old hash.
+func (db *DB) rehashPassword(id int, password string) error {
+	hash, err := db.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	return db.update(func(dbs *DBStructure) error {
+		user, exists := dbs.Users[id]
+		if !exists {
+			return errors.New("User with requested id doesn't exist")
+		}
+		user.Hash = hash
+		dbs.Users[id] = user
+		log.Printf("Upgraded password hash for user %d", id)
+		return nil
+	})
+}
 
-	chirp.Body = body
-	chirp.Id = id
-	chirp.AuthorId = authorId
+// Stores a new chirp with the body, author, entities and references of the given one, filling in its id and creation time.
+func (db *DB) CreateChirp(chirp Chirp) (Chirp, error) {
+	err := db.update(func(dbs *DBStructure) error {
+		if chirp.InReplyTo != 0 {
+			parent, exists := dbs.Chirps[chirp.InReplyTo]
+			if !exists || !dbs.chirpAvailable(parent) {
+				return ErrParentNotFound
+			}
+			// Replying to a rechirp joins the conversation around the original
+			if parent.RechirpOf != 0 {
+				chirp.InReplyTo = parent.RechirpOf
+			}
+		}
+		err := dbs.checkMediaOwner(chirp.MediaIds, chirp.AuthorId)
+		if err != nil {
+			return err
+		}
+		if chirp.QuoteOf != 0 {
+			quoted, exists := dbs.Chirps[chirp.QuoteOf]
+			if !exists || !dbs.chirpAvailable(quoted) {
+				return ErrQuotedNotFound
+			}
+			if quoted.RechirpOf != 0 {
+				chirp.QuoteOf = quoted.RechirpOf
+			}
+		}
 
-	dbs.Chirps[id] = chirp
+		chirp.Id = dbs.NextChirpId
+		dbs.NextChirpId++
+		chirp.CreatedAt = time.Now()
 
-	err = db.write(dbs)
+		dbs.Chirps[chirp.Id] = chirp
+		chirp = dbs.chirpDetails().fill(chirp)
+		return nil
+	})
 	if err != nil {
-		log.Printf("Error writing database when adding chirp: %v", chirp)
+		log.Printf("Error adding chirp %v: %v", chirp, err)
+		return Chirp{}, err
 	}
-	return chirp, err
+	return chirp, nil
 }
 
 func (db *DB) GetSortedChirps() ([]Chirp, error) {
@@ -207,11 +417,12 @@ func (db *DB) GetSortedChirps() ([]Chirp, error) {
 		return nil, err
 	}
 
-	chirps := make([]Chirp, len(dbs.Chirps))
-	i := 0
-	for k := range dbs.Chirps {
-		chirps[i] = dbs.Chirps[k]
-		i++
+	details := dbs.chirpDetails()
+	chirps := make([]Chirp, 0, len(dbs.Chirps))
+	for _, c := range dbs.Chirps {
+		if dbs.chirpAvailable(c) {
+			chirps = append(chirps, details.fill(c))
+		}
 	}
 
 	slices.SortFunc(chirps, func(a, b Chirp) int {
@@ -227,29 +438,98 @@ func (db *DB) GetChirp(id int) (Chirp, error) {
 	}
 
 	chirp, exists := dbs.Chirps[id]
-	if !exists {
-		return Chirp{}, errors.New("Chirp with requested id doesn't exist")
+	if !exists || !dbs.chirpAvailable(chirp) {
+		return Chirp{}, ErrChirpNotFound
 	}
 
-	return chirp, nil
+	return dbs.chirpDetails().fill(chirp), nil
 }
 
-func (db *DB) DeleteChirp(id int) error {
-	dbs, err := db.load()
-	if err != nil {
-		return err
+// Everything about chirps that is derived from other chirps and tables, and filled in whenever chirps are returned.
+type chirpDetails struct {
+	dbs      *DBStructure
+	replies  map[int]int
+	likes    map[int]int
+	rechirps map[int]int
+}
+
+func (dbs *DBStructure) chirpDetails() chirpDetails {
+	details := chirpDetails{dbs: dbs, replies: make(map[int]int), likes: make(map[int]int), rechirps: make(map[int]int)}
+	for _, c := range dbs.Chirps {
+		if !dbs.chirpAvailable(c) {
+			continue
+		}
+		if c.InReplyTo != 0 {
+			details.replies[c.InReplyTo]++
+		}
+		if c.RechirpOf != 0 {
+			details.rechirps[c.RechirpOf]++
+		}
 	}
-	delete(dbs.Chirps, id)
-	return nil
+	for chirpId, likers := range dbs.Likes {
+		for userId := range likers {
+			// Like their chirps, likes by banned users no longer count
+			if !dbs.Users[userId].Sanction.banned() {
+				details.likes[chirpId]++
+			}
+		}
+	}
+	return details
+}
+
+func (cd chirpDetails) fill(c Chirp) Chirp {
+	if c.Entities == nil {
+		c.Entities = []entities.Entity{}
+	}
+	c.ReplyCount = cd.replies[c.Id]
+	c.LikeCount = cd.likes[c.Id]
+	c.RechirpCount = cd.rechirps[c.Id]
+	c.Media = nil
+	for _, id := range c.MediaIds {
+		c.Media = append(c.Media, cd.dbs.Media[id])
+	}
+	if ref := c.referenced(); ref != 0 {
+		embedded := cd.dbs.Chirps[ref]
+		if cd.dbs.chirpAvailable(embedded) {
+			// Only one level is embedded, so quotes of quotes just carry the id of what they quote
+			embedded = cd.fill(embedded)
+			embedded.Embedded = nil
+		} else {
+			embedded = embedded.tombstone()
+			embedded.Id = ref
+		}
+		c.Embedded = &embedded
+	}
+	return c
+}
+
+// Marks the chirp as deleted. It stays in the database as a tombstone for the replies to it.
+func (db *DB) DeleteChirp(id int) error {
+	return db.update(func(dbs *DBStructure) error {
+		chirp, exists := dbs.Chirps[id]
+		if !exists || chirp.DeletedAt != nil {
+			return ErrChirpNotFound
+		}
+		now := time.Now()
+		chirp.DeletedAt = &now
+		dbs.Chirps[id] = chirp
+		return nil
+	})
 }
 
-func NewDB(path string) (*DB, error) {
+func NewDB(path string, hasher *password.Scheme) (*DB, error) {
 	log.Println("Creating new database connection")
+	dummyHash, err := hasher.Hash("chirpy-dummy-password")
+	if err != nil {
+		return nil, err
+	}
 	db := DB{
-		path: path,
-		mux:  &sync.RWMutex{},
+		path:      path,
+		mux:       &sync.RWMutex{},
+		hasher:    hasher,
+		dummyHash: dummyHash,
 	}
-	err := db.ensure()
+	err = db.ensure()
 	if err != nil {
 		return nil, err
 	}
@@ -259,7 +539,7 @@ func NewDB(path string) (*DB, error) {
 
 func (db *DB) ensure() error {
 	log.Printf("Ensure that database at %v exists", db.path)
-	dbs := DBStructure{Chirps: make(map[int]Chirp), Users: make(map[int]user), RevokedTokens: make(map[string]time.Time), NextChirpId: 1}
+	dbs := newDBStructure()
 	_, err := os.ReadFile(db.path)
 	if err != nil {
 		err = db.write(dbs)
@@ -269,9 +549,35 @@ func (db *DB) ensure() error {
 }
 
 func (db *DB) load() (DBStructure, error) {
-	dbs := DBStructure{}
 	db.mux.RLock()
 	defer db.mux.RUnlock()
+	return db.read()
+}
+
+// Returned from update functions that find nothing to change, so that the database isn't rewritten for nothing.
+var errNoChange = errors.New("Nothing to change")
+
+// Loads the database, lets fn change it and writes it back, holding the write lock throughout. Every read-modify-write goes through here, as separate loads and writes would let concurrent requests act on the same stale state and overwrite each other's changes. Nothing is written if fn returns an error.
+func (db *DB) update(fn func(dbs *DBStructure) error) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	dbs, err := db.read()
+	if err != nil {
+		return err
+	}
+	err = fn(&dbs)
+	if errors.Is(err, errNoChange) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return db.save(dbs)
+}
+
+// Callers must hold the lock.
+func (db *DB) read() (DBStructure, error) {
+	dbs := newDBStructure()
 	data, err := os.ReadFile(db.path)
 	if err != nil {
 		log.Printf("Error reading database file %v while loading: %v", db.path, err)
@@ -287,14 +593,19 @@ func (db *DB) load() (DBStructure, error) {
 }
 
 func (db *DB) write(dbs DBStructure) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+	return db.save(dbs)
+}
+
+// Callers must hold the write lock.
+func (db *DB) save(dbs DBStructure) error {
 	log.Println("Writing to database at", db.path)
 	data, err := json.Marshal(dbs)
 	if err != nil {
 		return err
 	}
 
-	db.mux.Lock()
-	defer db.mux.Unlock()
 	err = os.WriteFile(db.path, data, 0600)
 	if err != nil {
 		log.Println("Error writing to database:", err)
@@ -311,12 +622,12 @@ func (db *DB) TokenRevoked(tokenString string) (bool, error) {
 	return ok, nil
 }
 
-func (db *DB) RevokeToken(tokenString string, time time.Time) error {
-	dbs, err := db.load()
-	if err != nil {
-		return err
-	}
-	dbs.RevokedTokens[tokenString] = time
-	err = db.write(dbs)
-	return err
+// Revokes the refresh token. It stays on the revocation list until it expires, after which it would be refused anyway.
+func (db *DB) RevokeToken(tokenString string, expiresAt time.Time) error {
+	return db.update(func(dbs *DBStructure) error {
+		dbs.pruneRefreshTokens(time.Now())
+		dbs.RevokedTokens[tokenString] = expiresAt
+		delete(dbs.RefreshTokens, tokenString)
+		return nil
+	})
 }
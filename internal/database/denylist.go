@@ -0,0 +1,40 @@
+package database
+
+import "time"
+
+// Access tokens are denylisted by their ID (the jti claim) until they would have expired anyway.
+func (db *DB) DenyToken(tokenId string, expiresAt time.Time) error {
+	return db.update(func(dbs *DBStructure) error {
+		dbs.DeniedTokens[tokenId] = expiresAt
+		return nil
+	})
+}
+
+func (db *DB) GetDeniedTokens() (map[string]time.Time, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	return dbs.DeniedTokens, nil
+}
+
+// Forgets denylisted tokens that have expired by now, since they can no longer be used anyway.
+func (db *DB) PruneDeniedTokens(now time.Time) (int, error) {
+	pruned := 0
+	err := db.update(func(dbs *DBStructure) error {
+		for id, expiresAt := range dbs.DeniedTokens {
+			if now.After(expiresAt) {
+				delete(dbs.DeniedTokens, id)
+				pruned++
+			}
+		}
+		if pruned == 0 {
+			return errNoChange
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
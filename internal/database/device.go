@@ -0,0 +1,137 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrDeviceCodeNotFound = errors.New("Device code not found")
+var ErrDeviceCodeExpired = errors.New("Device code has expired")
+var ErrAuthorizationPending = errors.New("Device authorization is still pending")
+var ErrSlowDown = errors.New("Device is polling too fast")
+var ErrDeviceAccessDenied = errors.New("Device authorization was denied")
+
+const DeviceAuthorizationPending = "pending"
+const DeviceAuthorizationApproved = "approved"
+const DeviceAuthorizationDenied = "denied"
+
+// How much the polling interval grows every time a device polls too fast, see RFC 8628 section 3.5
+const slowDownIncrement = 5 * time.Second
+
+// A device waiting for a user to approve it in a browser. Stored under the hash of the device code, with the user code hashed as well.
+type DeviceAuthorization struct {
+	ClientId     string        `json:"client_id"`
+	Scopes       []string      `json:"scopes"`
+	UserCodeHash string        `json:"user_code_hash"`
+	Status       string        `json:"status"`
+	UserId       int           `json:"user_id,omitempty"`
+	Interval     time.Duration `json:"interval"`
+	LastPolledAt time.Time     `json:"last_polled_at"`
+	ExpiresAt    time.Time     `json:"expires_at"`
+}
+
+func (db *DB) CreateDeviceAuthorization(deviceCodeHash string, auth DeviceAuthorization) error {
+	auth.Status = DeviceAuthorizationPending
+	return db.update(func(dbs *DBStructure) error {
+		now := time.Now()
+		for k, v := range dbs.DeviceAuthorizations {
+			if now.After(v.ExpiresAt) {
+				delete(dbs.DeviceAuthorizations, k)
+			}
+		}
+		dbs.DeviceAuthorizations[deviceCodeHash] = auth
+		return nil
+	})
+}
+
+// Looks up a pending, unexpired authorization by its user code.
+func (db *DB) GetDeviceAuthorization(userCodeHash string) (DeviceAuthorization, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	_, auth, err := dbs.pendingDeviceAuthorization(userCodeHash)
+	return auth, err
+}
+
+// Approves or denies the pending authorization with the user code on behalf of the user.
+func (db *DB) ResolveDeviceAuthorization(userCodeHash string, userId int, approved bool) (DeviceAuthorization, error) {
+	var auth DeviceAuthorization
+	err := db.update(func(dbs *DBStructure) error {
+		deviceCodeHash, pending, err := dbs.pendingDeviceAuthorization(userCodeHash)
+		if err != nil {
+			return err
+		}
+		pending.Status = DeviceAuthorizationDenied
+		if approved {
+			pending.Status = DeviceAuthorizationApproved
+			pending.UserId = userId
+		}
+		dbs.DeviceAuthorizations[deviceCodeHash] = pending
+		auth = pending
+		return nil
+	})
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	return auth, nil
+}
+
+func (dbs *DBStructure) pendingDeviceAuthorization(userCodeHash string) (string, DeviceAuthorization, error) {
+	now := time.Now()
+	for k, v := range dbs.DeviceAuthorizations {
+		if v.UserCodeHash == userCodeHash && v.Status == DeviceAuthorizationPending && now.Before(v.ExpiresAt) {
+			return k, v, nil
+		}
+	}
+	return "", DeviceAuthorization{}, ErrDeviceCodeNotFound
+}
+
+// Records that the client polled for the device code. Once the user has approved it, the authorization is returned and consumed. Otherwise the error says why the client has to keep waiting or give up.
+func (db *DB) PollDeviceAuthorization(deviceCodeHash, clientId string) (DeviceAuthorization, error) {
+	var auth DeviceAuthorization
+	// Every outcome is recorded, so the reason to keep waiting or give up is returned after the write
+	var outcome error
+	err := db.update(func(dbs *DBStructure) error {
+		var exists bool
+		auth, exists = dbs.DeviceAuthorizations[deviceCodeHash]
+		if !exists || auth.ClientId != clientId {
+			return ErrDeviceCodeNotFound
+		}
+		now := time.Now()
+		if now.After(auth.ExpiresAt) {
+			delete(dbs.DeviceAuthorizations, deviceCodeHash)
+			outcome = ErrDeviceCodeExpired
+			return nil
+		}
+
+		// The interval starts with the first poll, however soon it comes after the code was issued
+		tooFast := !auth.LastPolledAt.IsZero() && now.Sub(auth.LastPolledAt) < auth.Interval
+		auth.LastPolledAt = now
+		if tooFast {
+			auth.Interval += slowDownIncrement
+			dbs.DeviceAuthorizations[deviceCodeHash] = auth
+			outcome = ErrSlowDown
+			return nil
+		}
+
+		switch auth.Status {
+		case DeviceAuthorizationApproved:
+			delete(dbs.DeviceAuthorizations, deviceCodeHash)
+		case DeviceAuthorizationDenied:
+			delete(dbs.DeviceAuthorizations, deviceCodeHash)
+			outcome = ErrDeviceAccessDenied
+		default:
+			dbs.DeviceAuthorizations[deviceCodeHash] = auth
+			outcome = ErrAuthorizationPending
+		}
+		return nil
+	})
+	if err != nil {
+		return DeviceAuthorization{}, err
+	}
+	if outcome != nil {
+		return DeviceAuthorization{}, outcome
+	}
+	return auth, nil
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/entities"
+)
+
+// Fills in the ids of mentioned users, leaving out mentions of email addresses that don't belong to anyone.
+func (db *DB) ResolveMentions(ents []entities.Entity) ([]entities.Entity, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	resolved := make([]entities.Entity, 0, len(ents))
+	for _, e := range ents {
+		if e.Type == entities.TypeMention {
+			e.UserId = 0
+			for _, u := range dbs.Users {
+				if strings.EqualFold(u.Email, e.Value) {
+					e.UserId = u.Id
+					break
+				}
+			}
+			if e.UserId == 0 {
+				continue
+			}
+		}
+		resolved = append(resolved, e)
+	}
+	return resolved, nil
+}
+
+// Returns the available chirps tagged with the hashtag, newest first.
+func (db *DB) GetChirpsByHashtag(tag string) ([]Chirp, error) {
+	tag = entities.NormalizeHashtag(tag)
+	return db.getChirpsWithEntity(func(e entities.Entity) bool {
+		return e.Type == entities.TypeHashtag && e.Value == tag
+	})
+}
+
+// Returns the available chirps that mention the user, newest first.
+func (db *DB) GetMentions(userId int) ([]Chirp, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := dbs.Users[userId]; !exists {
+		return nil, ErrUserNotFound
+	}
+	return db.getChirpsWithEntity(func(e entities.Entity) bool {
+		return e.Type == entities.TypeMention && e.UserId == userId
+	})
+}
+
+func (db *DB) getChirpsWithEntity(match func(entities.Entity) bool) ([]Chirp, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	details := dbs.chirpDetails()
+	chirps := []Chirp{}
+	for _, c := range dbs.Chirps {
+		if dbs.chirpAvailable(c) && slices.ContainsFunc(c.Entities, match) {
+			chirps = append(chirps, details.fill(c))
+		}
+	}
+	slices.SortFunc(chirps, func(a, b Chirp) int {
+		return b.Id - a.Id
+	})
+	return chirps, nil
+}
+
+// A chirp being tagged with a hashtag, for working out what is trending.
+type HashtagUse struct {
+	Tag string
+	At  time.Time
+}
+
+// Returns the hashtags of available chirps posted since the given time. Chirps by users who are currently suspended or banned are left out.
+func (db *DB) GetHashtagUses(since time.Time) ([]HashtagUse, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	uses := []HashtagUse{}
+	for _, c := range dbs.Chirps {
+		if c.CreatedAt.Before(since) || !dbs.chirpAvailable(c) || dbs.Users[c.AuthorId].Sanction.active(now) {
+			continue
+		}
+		for _, e := range c.Entities {
+			if e.Type == entities.TypeHashtag {
+				uses = append(uses, HashtagUse{Tag: e.Value, At: c.CreatedAt})
+			}
+		}
+	}
+	return uses, nil
+}
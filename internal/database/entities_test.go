@@ -0,0 +1,94 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/entities"
+)
+
+func TestGetHashtagUses(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name     string
+		sanction *Sanction
+		want     []string
+	}{
+		{"no sanction", nil, []string{"go"}},
+		{"suspended", &Sanction{Kind: SanctionSuspended, Until: &future}, []string{}},
+		{"suspension over", &Sanction{Kind: SanctionSuspended, Until: &past}, []string{"go"}},
+		{"banned", &Sanction{Kind: SanctionBanned}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			author := newTestUser(t, db, "author@example.com")
+			_, err := db.CreateChirp(Chirp{Body: "#Go", AuthorId: author.Id, Entities: entities.Extract("#Go")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.sanction != nil {
+				err = db.SanctionUser(author.Id, *tt.sanction)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			uses, err := db.GetHashtagUses(past)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, u := range uses {
+				got = append(got, u.Tag)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Hashtags used = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetHashtagUsesLeavesOut(t *testing.T) {
+	db := newTestDB(t)
+	author := newTestUser(t, db, "author@example.com")
+	newChirp := func(body string) Chirp {
+		chirp, err := db.CreateChirp(Chirp{Body: body, AuthorId: author.Id, Entities: entities.Extract(body)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chirp
+	}
+	newChirp("#kept and #both")
+	deleted := newChirp("#deleted")
+	old := newChirp("#old")
+	newChirp("no tags, only @someone and https://example.com")
+
+	err := db.DeleteChirp(deleted.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.update(func(dbs *DBStructure) error {
+		c := dbs.Chirps[old.Id]
+		c.CreatedAt = time.Now().Add(-2 * time.Hour)
+		dbs.Chirps[old.Id] = c
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uses, err := db.GetHashtagUses(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, u := range uses {
+		got = append(got, u.Tag)
+	}
+	if want := []string{"kept", "both"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Hashtags used = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// A chirp that the moderation filter let through but wants a moderator to look at.
+type FlaggedChirp struct {
+	ChirpId int `json:"chirp_id"`
+	// The rules that flagged the chirp, and the words they matched
+	RuleIds   []string  `json:"rule_ids"`
+	Matches   []string  `json:"matches"`
+	FlaggedAt time.Time `json:"flagged_at"`
+	// Filled in when listing flagged chirps
+	Chirp *Chirp `json:"chirp,omitempty"`
+}
+
+// Records why the chirp was flagged and puts it in the moderation queue. Flagging it again, for example after an edit, replaces the earlier record.
+func (db *DB) FlagChirp(chirpId int, ruleIds, matches []string) error {
+	return db.update(func(dbs *DBStructure) error {
+		if _, exists := dbs.Chirps[chirpId]; !exists {
+			return ErrChirpNotFound
+		}
+		dbs.FlaggedChirps[chirpId] = FlaggedChirp{ChirpId: chirpId, RuleIds: ruleIds, Matches: matches, FlaggedAt: time.Now()}
+		reason := "Flagged by moderation rules: " + strings.Join(ruleIds, ", ")
+		queued := false
+		for id, r := range dbs.Reports {
+			if r.ChirpId == chirpId && r.Source == ReportSourceFilter && r.Status != ReportResolved {
+				r.Reason = reason
+				dbs.Reports[id] = r
+				queued = true
+			}
+		}
+		if !queued {
+			dbs.addReport(Report{ChirpId: chirpId, Source: ReportSourceFilter, Reason: reason})
+		}
+		return nil
+	})
+}
+
+// Returns the flagged chirps that are still up, oldest flag first.
+func (db *DB) GetFlaggedChirps() ([]FlaggedChirp, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	details := dbs.chirpDetails()
+	flagged := []FlaggedChirp{}
+	for _, f := range dbs.FlaggedChirps {
+		chirp, exists := dbs.Chirps[f.ChirpId]
+		if !exists || !dbs.chirpAvailable(chirp) {
+			continue
+		}
+		chirp = details.fill(chirp)
+		f.Chirp = &chirp
+		flagged = append(flagged, f)
+	}
+	slices.SortFunc(flagged, func(a, b FlaggedChirp) int {
+		return a.FlaggedAt.Compare(b.FlaggedAt)
+	})
+	return flagged, nil
+}
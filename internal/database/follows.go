@@ -0,0 +1,161 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrCannotFollowSelf = errors.New("Users can't follow themselves")
+
+// A user as shown to others, along with their place in the follow graph.
+type UserProfile struct {
+	SafeUser
+	FollowerCount  int `json:"follower_count"`
+	FollowingCount int `json:"following_count"`
+}
+
+// Banned users disappear from follower lists and counts, like their chirps do.
+func (dbs *DBStructure) userVisible(id int) bool {
+	u, exists := dbs.Users[id]
+	return exists && !u.Sanction.banned()
+}
+
+func (db *DB) GetUserProfile(id int) (UserProfile, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return UserProfile{}, err
+	}
+	user, exists := dbs.Users[id]
+	if !exists {
+		return UserProfile{}, ErrUserNotFound
+	}
+	profile := UserProfile{SafeUser: user.clean()}
+	profile.FollowerCount = len(dbs.followers(id))
+	profile.FollowingCount = len(dbs.following(id))
+	return profile, nil
+}
+
+// Following someone twice has no further effect.
+func (db *DB) Follow(followerId, followeeId int) error {
+	if followerId == followeeId {
+		return ErrCannotFollowSelf
+	}
+	return db.update(func(dbs *DBStructure) error {
+		if !dbs.userVisible(followeeId) {
+			return ErrUserNotFound
+		}
+		if _, following := dbs.Follows[followerId][followeeId]; following {
+			return errNoChange
+		}
+		if dbs.Follows[followerId] == nil {
+			dbs.Follows[followerId] = make(map[int]time.Time)
+		}
+		dbs.Follows[followerId][followeeId] = time.Now()
+		return nil
+	})
+}
+
+func (db *DB) Unfollow(followerId, followeeId int) error {
+	return db.update(func(dbs *DBStructure) error {
+		if _, exists := dbs.Users[followeeId]; !exists {
+			return ErrUserNotFound
+		}
+		if _, following := dbs.Follows[followerId][followeeId]; !following {
+			return errNoChange
+		}
+		delete(dbs.Follows[followerId], followeeId)
+		if len(dbs.Follows[followerId]) == 0 {
+			delete(dbs.Follows, followerId)
+		}
+		return nil
+	})
+}
+
+// Returns the users following the user, sorted by id.
+func (db *DB) GetFollowers(id int) ([]SafeUser, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := dbs.Users[id]; !exists {
+		return nil, ErrUserNotFound
+	}
+	return dbs.cleanUsers(dbs.followers(id)), nil
+}
+
+// Returns the users the user follows, sorted by id.
+func (db *DB) GetFollowing(id int) ([]SafeUser, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := dbs.Users[id]; !exists {
+		return nil, ErrUserNotFound
+	}
+	return dbs.cleanUsers(dbs.following(id)), nil
+}
+
+func (dbs *DBStructure) followers(id int) []int {
+	ids := []int{}
+	for followerId, followees := range dbs.Follows {
+		if _, ok := followees[id]; ok && dbs.userVisible(followerId) {
+			ids = append(ids, followerId)
+		}
+	}
+	return ids
+}
+
+func (dbs *DBStructure) following(id int) []int {
+	ids := []int{}
+	for followeeId := range dbs.Follows[id] {
+		if dbs.userVisible(followeeId) {
+			ids = append(ids, followeeId)
+		}
+	}
+	return ids
+}
+
+func (dbs *DBStructure) cleanUsers(ids []int) []SafeUser {
+	slices.Sort(ids)
+	users := make([]SafeUser, len(ids))
+	for i, id := range ids {
+		users[i] = dbs.Users[id].clean()
+	}
+	return users
+}
+
+// Returns up to limit chirps by the user and everyone they follow, newest first. Pass the returned cursor as before to get the next page; it is 0 when there are no more chirps, and a before of 0 starts from the newest chirp.
+//
+// Chirp ids only ever increase, so walking down from the cursor visits chirps in reverse chronological order and can stop as soon as the page is full.
+func (db *DB) GetTimeline(userId, before, limit int) ([]Chirp, int, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, exists := dbs.Users[userId]; !exists {
+		return nil, 0, ErrUserNotFound
+	}
+	authors := map[int]bool{userId: true}
+	for followeeId := range dbs.Follows[userId] {
+		authors[followeeId] = true
+	}
+
+	if before <= 0 || before > dbs.NextChirpId {
+		before = dbs.NextChirpId
+	}
+	details := dbs.chirpDetails()
+	chirps := []Chirp{}
+	for id := before - 1; id > 0; id-- {
+		c, exists := dbs.Chirps[id]
+		if !exists || !authors[c.AuthorId] || !dbs.chirpAvailable(c) {
+			continue
+		}
+		// Finding one more chirp than fits on the page shows that there is a next page
+		if len(chirps) == limit {
+			return chirps, chirps[limit-1].Id, nil
+		}
+		chirps = append(chirps, details.fill(c))
+	}
+	return chirps, 0, nil
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrInviteNotFound = errors.New("Invite not found")
+var ErrInvalidInvite = errors.New("Invite code is invalid, expired or used up")
+
+type invite struct {
+	Id   int    `json:"id"`
+	Note string `json:"note"`
+	// Only a hash of the code is stored, the code itself is shown to the admin once on creation
+	Hash      string     `json:"hash"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type Invite struct {
+	Id        int        `json:"id"`
+	Note      string     `json:"note"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (i invite) clean() Invite {
+	return Invite{Id: i.Id, Note: i.Note, MaxUses: i.MaxUses, Uses: i.Uses, CreatedAt: i.CreatedAt, ExpiresAt: i.ExpiresAt}
+}
+
+func (i invite) usable(now time.Time) bool {
+	return i.Uses < i.MaxUses && (i.ExpiresAt == nil || now.Before(*i.ExpiresAt))
+}
+
+func (db *DB) CreateInvite(note, hash string, maxUses int, expiresAt *time.Time) (Invite, error) {
+	var inv invite
+	err := db.update(func(dbs *DBStructure) error {
+		inv = invite{
+			Id:        dbs.NextInviteId,
+			Note:      note,
+			Hash:      hash,
+			MaxUses:   maxUses,
+			CreatedAt: time.Now(),
+			ExpiresAt: expiresAt,
+		}
+		dbs.NextInviteId++
+		dbs.Invites[inv.Id] = inv
+		return nil
+	})
+	return inv.clean(), err
+}
+
+// Lists every invite, including expired and used up ones, oldest first.
+func (db *DB) GetInvites() ([]Invite, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	invites := make([]Invite, 0, len(dbs.Invites))
+	for _, inv := range dbs.Invites {
+		invites = append(invites, inv.clean())
+	}
+	slices.SortFunc(invites, func(a, b Invite) int {
+		return a.Id - b.Id
+	})
+	return invites, nil
+}
+
+func (db *DB) DeleteInvite(id int) error {
+	return db.update(func(dbs *DBStructure) error {
+		if _, exists := dbs.Invites[id]; !exists {
+			return ErrInviteNotFound
+		}
+		delete(dbs.Invites, id)
+		return nil
+	})
+}
+
+// Creates a user who signed up with an invite, counting the signup against the invite. Both happen in one update, so concurrent signups can't use an invite more often than it allows.
+func (db *DB) CreateUserWithInvite(email, password, inviteHash string) (SafeUser, error) {
+	hash, err := db.hasher.Hash(password)
+	if err != nil {
+		return SafeUser{}, err
+	}
+	u := user{Email: email, Hash: hash}
+	err = db.update(func(dbs *DBStructure) error {
+		id, inv, exists := dbs.inviteByHash(inviteHash)
+		if !exists || !inv.usable(time.Now()) {
+			return ErrInvalidInvite
+		}
+		u.InviteId = id
+		err := dbs.addUser(&u)
+		if err != nil {
+			return err
+		}
+		inv.Uses++
+		dbs.Invites[id] = inv
+		return nil
+	})
+	return u.clean(), err
+}
+
+func (dbs *DBStructure) inviteByHash(hash string) (int, invite, bool) {
+	for id, inv := range dbs.Invites {
+		if inv.Hash == hash {
+			return id, inv, true
+		}
+	}
+	return 0, invite{}, false
+}
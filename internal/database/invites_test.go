@@ -0,0 +1,129 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCreateUserWithInvite(t *testing.T) {
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	tests := []struct {
+		name      string
+		maxUses   int
+		uses      int
+		expiresAt *time.Time
+		hash      string
+		want      error
+	}{
+		{"unused", 1, 0, nil, "hash", nil},
+		{"uses left", 3, 2, &future, "hash", nil},
+		{"used up", 3, 3, nil, "hash", ErrInvalidInvite},
+		{"expired", 3, 0, &past, "hash", ErrInvalidInvite},
+		{"unknown code", 3, 0, nil, "other", ErrInvalidInvite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			inv, err := db.CreateInvite("note", "hash", tt.maxUses, tt.expiresAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range tt.uses {
+				_, err = db.CreateUserWithInvite(fmt.Sprintf("user%d@example.com", i), "correct horse", "hash")
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			user, err := db.CreateUserWithInvite("new@example.com", "correct horse", tt.hash)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("CreateUserWithInvite = %v, want %v", err, tt.want)
+			}
+			invites, err := db.GetInvites()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantUses := tt.uses
+			if tt.want == nil {
+				wantUses++
+			}
+			if invites[0].Uses != wantUses {
+				t.Errorf("Invite used %d times, want %d", invites[0].Uses, wantUses)
+			}
+			_, err = db.GetUserByEmail("new@example.com")
+			if exists := err == nil; exists != (tt.want == nil) {
+				t.Errorf("User created = %v after CreateUserWithInvite = %v", exists, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			dbs, err := db.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := dbs.Users[user.Id].InviteId; got != inv.Id {
+				t.Errorf("User signed up with invite %d, want %d", got, inv.Id)
+			}
+		})
+	}
+}
+
+func TestCreateUserWithInviteEmailTaken(t *testing.T) {
+	db := newTestDB(t)
+	newTestUser(t, db, "user@example.com")
+	_, err := db.CreateInvite("note", "hash", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateUserWithInvite("USER@example.com", "correct horse", "hash")
+	if !errors.Is(err, ErrEmailTaken) {
+		t.Fatalf("CreateUserWithInvite = %v, want ErrEmailTaken", err)
+	}
+	// A failed signup doesn't use up the invite
+	invites, err := db.GetInvites()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invites[0].Uses != 0 {
+		t.Errorf("Invite used %d times, want 0", invites[0].Uses)
+	}
+}
+
+func TestCreateUserWithInviteConcurrent(t *testing.T) {
+	db := newTestDB(t)
+	const maxUses = 3
+	_, err := db.CreateInvite("note", "hash", maxUses, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = db.CreateUserWithInvite(fmt.Sprintf("user%d@example.com", i), "correct horse", "hash")
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		if err == nil {
+			created++
+		} else if !errors.Is(err, ErrInvalidInvite) {
+			t.Errorf("CreateUserWithInvite = %v, want ErrInvalidInvite", err)
+		}
+	}
+	invites, err := db.GetInvites()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != maxUses || invites[0].Uses != maxUses {
+		t.Errorf("%d users created and invite used %d times, want %d", created, invites[0].Uses, maxUses)
+	}
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"slices"
+	"time"
+)
+
+// Likes the chirp on behalf of the user. Liking a rechirp likes the original, and liking a chirp twice has no further effect.
+func (db *DB) LikeChirp(chirpId, userId int) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(dbs *DBStructure) error {
+		c, err := dbs.actionTarget(chirpId)
+		if err != nil {
+			return err
+		}
+		chirpId = c.Id
+		_, liked := dbs.Likes[chirpId][userId]
+		if !liked {
+			if dbs.Likes[chirpId] == nil {
+				dbs.Likes[chirpId] = make(map[int]time.Time)
+			}
+			dbs.Likes[chirpId][userId] = time.Now()
+		}
+		chirp = dbs.chirpDetails().fill(c)
+		if liked {
+			return errNoChange
+		}
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// Removes the user's like from the chirp, or from the original if it is a rechirp, if there is one.
+func (db *DB) UnlikeChirp(chirpId, userId int) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(dbs *DBStructure) error {
+		c, err := dbs.actionTarget(chirpId)
+		if err != nil {
+			return err
+		}
+		chirpId = c.Id
+		_, liked := dbs.Likes[chirpId][userId]
+		if liked {
+			delete(dbs.Likes[chirpId], userId)
+			if len(dbs.Likes[chirpId]) == 0 {
+				delete(dbs.Likes, chirpId)
+			}
+		}
+		chirp = dbs.chirpDetails().fill(c)
+		if !liked {
+			return errNoChange
+		}
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// Returns the ids of every chirp the user likes.
+func (db *DB) GetLikedChirpIds(userId int) (map[int]bool, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	liked := make(map[int]bool)
+	for chirpId, likers := range dbs.Likes {
+		if _, ok := likers[userId]; ok {
+			liked[chirpId] = true
+		}
+	}
+	return liked, nil
+}
+
+// Returns the available chirps the user likes, most recently liked first.
+func (db *DB) GetLikedChirps(userId int) ([]Chirp, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := dbs.Users[userId]; !exists {
+		return nil, ErrUserNotFound
+	}
+
+	type like struct {
+		chirp   Chirp
+		likedAt time.Time
+	}
+	likes := []like{}
+	details := dbs.chirpDetails()
+	for chirpId, likers := range dbs.Likes {
+		likedAt, ok := likers[userId]
+		chirp, exists := dbs.Chirps[chirpId]
+		if ok && exists && dbs.chirpAvailable(chirp) {
+			likes = append(likes, like{chirp: details.fill(chirp), likedAt: likedAt})
+		}
+	}
+	slices.SortFunc(likes, func(a, b like) int {
+		return b.likedAt.Compare(a.likedAt)
+	})
+
+	chirps := make([]Chirp, len(likes))
+	for i, l := range likes {
+		chirps[i] = l.chirp
+	}
+	return chirps, nil
+}
@@ -0,0 +1,38 @@
+package database
+
+import "time"
+
+const LockoutEventLocked = "locked"
+const LockoutEventUnlocked = "unlocked"
+
+// Only the most recent events are kept, so a sustained attack can't grow the database without bound
+const maxLockoutEvents = 1000
+
+type LockoutEvent struct {
+	Event       string     `json:"event"`
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures,omitempty"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	At          time.Time  `json:"at"`
+}
+
+func (db *DB) RecordLockoutEvent(event LockoutEvent) error {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+	return db.update(func(dbs *DBStructure) error {
+		dbs.LockoutEvents = append(dbs.LockoutEvents, event)
+		if l := len(dbs.LockoutEvents); l > maxLockoutEvents {
+			dbs.LockoutEvents = dbs.LockoutEvents[l-maxLockoutEvents:]
+		}
+		return nil
+	})
+}
+
+func (db *DB) GetLockoutEvents() ([]LockoutEvent, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	return dbs.LockoutEvents, nil
+}
@@ -0,0 +1,40 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrMediaNotFound = errors.New("Media doesn't exist or belongs to someone else")
+
+// An uploaded image that chirps can be attached to.
+type Media struct {
+	Id           string    `json:"id"`
+	OwnerId      int       `json:"owner_id"`
+	ContentType  string    `json:"content_type"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (db *DB) CreateMedia(media Media) (Media, error) {
+	media.CreatedAt = time.Now()
+	err := db.update(func(dbs *DBStructure) error {
+		dbs.Media[media.Id] = media
+		return nil
+	})
+	return media, err
+}
+
+// Checks that the media exist and that the user uploaded them, so that they can be attached to the user's chirp.
+func (dbs *DBStructure) checkMediaOwner(ids []string, userId int) error {
+	for _, id := range ids {
+		media, exists := dbs.Media[id]
+		if !exists || media.OwnerId != userId {
+			return ErrMediaNotFound
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"errors"
+	"slices"
+)
+
+var ErrTOTPAlreadyEnabled = errors.New("Two-factor authentication is already enabled")
+var ErrTOTPNotEnabled = errors.New("Two-factor authentication is not enabled")
+var ErrTOTPNotPending = errors.New("No two-factor enrollment in progress")
+var ErrTOTPCodeReused = errors.New("Two-factor code has already been used")
+var ErrRecoveryCodeInvalid = errors.New("Invalid recovery code")
+
+type TOTP struct {
+	Secret string `json:"secret,omitempty"`
+	// Set during enrollment, until the user proves that their authenticator works by confirming a code
+	PendingSecret string `json:"pending_secret,omitempty"`
+	// The time step of the last accepted code, to stop codes from being replayed
+	LastCounter uint64 `json:"last_counter,omitempty"`
+	// Hashes of the unused recovery codes
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+func (t TOTP) Enabled() bool {
+	return t.Secret != ""
+}
+
+func (db *DB) GetTOTP(userId int) (TOTP, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return TOTP{}, err
+	}
+	user, exists := dbs.Users[userId]
+	if !exists {
+		return TOTP{}, errors.New("User with requested id doesn't exist")
+	}
+	return user.TOTP, nil
+}
+
+// Checks and changes the user's TOTP state in one update, so that concurrent requests can't both accept the same code or recovery code.
+func (db *DB) updateTOTP(userId int, update func(*TOTP) error) error {
+	return db.update(func(dbs *DBStructure) error {
+		user, exists := dbs.Users[userId]
+		if !exists {
+			return errors.New("User with requested id doesn't exist")
+		}
+		err := update(&user.TOTP)
+		if err != nil {
+			return err
+		}
+		dbs.Users[userId] = user
+		return nil
+	})
+}
+
+// Starts enrollment with a new secret, replacing any enrollment already in progress.
+func (db *DB) BeginTOTPEnrollment(userId int, secret string) error {
+	return db.updateTOTP(userId, func(t *TOTP) error {
+		if t.Enabled() {
+			return ErrTOTPAlreadyEnabled
+		}
+		t.PendingSecret = secret
+		return nil
+	})
+}
+
+// Activates the pending secret once the user has confirmed a code generated from it.
+func (db *DB) ConfirmTOTPEnrollment(userId int, counter uint64, recoveryCodeHashes []string) error {
+	return db.updateTOTP(userId, func(t *TOTP) error {
+		if t.Enabled() {
+			return ErrTOTPAlreadyEnabled
+		}
+		if t.PendingSecret == "" {
+			return ErrTOTPNotPending
+		}
+		*t = TOTP{Secret: t.PendingSecret, LastCounter: counter, RecoveryCodes: recoveryCodeHashes}
+		return nil
+	})
+}
+
+// Records that the code for the given time step was accepted. Fails if that or a later code was accepted before.
+func (db *DB) UseTOTPCounter(userId int, counter uint64) error {
+	return db.updateTOTP(userId, func(t *TOTP) error {
+		if !t.Enabled() {
+			return ErrTOTPNotEnabled
+		}
+		if counter <= t.LastCounter {
+			return ErrTOTPCodeReused
+		}
+		t.LastCounter = counter
+		return nil
+	})
+}
+
+// Consumes a recovery code, so that each one can only be used once.
+func (db *DB) UseRecoveryCode(userId int, codeHash string) error {
+	return db.updateTOTP(userId, func(t *TOTP) error {
+		if !t.Enabled() {
+			return ErrTOTPNotEnabled
+		}
+		i := slices.Index(t.RecoveryCodes, codeHash)
+		if i < 0 {
+			return ErrRecoveryCodeInvalid
+		}
+		t.RecoveryCodes = slices.Delete(t.RecoveryCodes, i, i+1)
+		return nil
+	})
+}
+
+func (db *DB) DisableTOTP(userId int) error {
+	return db.updateTOTP(userId, func(t *TOTP) error {
+		if !t.Enabled() {
+			return ErrTOTPNotEnabled
+		}
+		*t = TOTP{}
+		return nil
+	})
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"crypto/subtle"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrClientNotFound = errors.New("OAuth client not found")
+var ErrInvalidClientSecret = errors.New("OAuth client authentication failed")
+var ErrInvalidAuthorizationCode = errors.New("Authorization code is invalid or expired")
+
+type oauthClient struct {
+	Id      string `json:"id"`
+	OwnerId int    `json:"owner_id"`
+	Name    string `json:"name"`
+	// Only a hash of the secret is stored. Public clients, such as mobile and single page apps, have no secret.
+	SecretHash   string    `json:"secret_hash,omitempty"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type OAuthClient struct {
+	Id           string    `json:"id"`
+	OwnerId      int       `json:"owner_id"`
+	Name         string    `json:"name"`
+	Confidential bool      `json:"confidential"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (c oauthClient) clean() OAuthClient {
+	return OAuthClient{Id: c.Id, OwnerId: c.OwnerId, Name: c.Name, Confidential: c.SecretHash != "", RedirectURIs: c.RedirectURIs, Scopes: c.Scopes, CreatedAt: c.CreatedAt}
+}
+
+// Codes are stored under their hash, and can be exchanged for tokens once.
+type AuthorizationCode struct {
+	ClientId    string   `json:"client_id"`
+	UserId      int      `json:"user_id"`
+	RedirectURI string   `json:"redirect_uri"`
+	Scopes      []string `json:"scopes"`
+	// The PKCE S256 challenge that the code verifier has to match
+	CodeChallenge string    `json:"code_challenge"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// Registers a client. secretHash is empty for public clients.
+func (db *DB) CreateOAuthClient(id string, ownerId int, name, secretHash string, redirectURIs, scopes []string) (OAuthClient, error) {
+	client := oauthClient{
+		Id:           id,
+		OwnerId:      ownerId,
+		Name:         name,
+		SecretHash:   secretHash,
+		RedirectURIs: redirectURIs,
+		Scopes:       scopes,
+		CreatedAt:    time.Now(),
+	}
+	err := db.update(func(dbs *DBStructure) error {
+		dbs.OAuthClients[id] = client
+		return nil
+	})
+	return client.clean(), err
+}
+
+func (db *DB) GetOAuthClient(id string) (OAuthClient, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return OAuthClient{}, err
+	}
+	client, exists := dbs.OAuthClients[id]
+	if !exists {
+		return OAuthClient{}, ErrClientNotFound
+	}
+	return client.clean(), nil
+}
+
+// Checks the credentials a client presents to the token endpoint. Public clients must not present a secret, confidential ones must present theirs.
+func (db *DB) AuthenticateOAuthClient(id, secretHash string) (OAuthClient, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return OAuthClient{}, err
+	}
+	client, exists := dbs.OAuthClients[id]
+	if !exists {
+		return OAuthClient{}, ErrClientNotFound
+	}
+	if subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(secretHash)) != 1 {
+		return OAuthClient{}, ErrInvalidClientSecret
+	}
+	return client.clean(), nil
+}
+
+// Lists the clients registered by the user, oldest first.
+func (db *DB) GetOAuthClients(ownerId int) ([]OAuthClient, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	clients := []OAuthClient{}
+	for _, c := range dbs.OAuthClients {
+		if c.OwnerId == ownerId {
+			clients = append(clients, c.clean())
+		}
+	}
+	slices.SortFunc(clients, func(a, b OAuthClient) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Id, b.Id)
+	})
+	return clients, nil
+}
+
+// Deletes the client, provided that it belongs to the user, along with its pending codes and every session it started.
+func (db *DB) DeleteOAuthClient(ownerId int, id string) error {
+	return db.update(func(dbs *DBStructure) error {
+		client, exists := dbs.OAuthClients[id]
+		if !exists || client.OwnerId != ownerId {
+			return ErrClientNotFound
+		}
+		delete(dbs.OAuthClients, id)
+		for k, code := range dbs.AuthorizationCodes {
+			if code.ClientId == id {
+				delete(dbs.AuthorizationCodes, k)
+			}
+		}
+		for tokenString, t := range dbs.RefreshTokens {
+			if t.ClientId == id {
+				dbs.RevokedTokens[tokenString] = t.ExpiresAt
+				delete(dbs.RefreshTokens, tokenString)
+			}
+		}
+		return nil
+	})
+}
+
+func (db *DB) CreateAuthorizationCode(codeHash string, code AuthorizationCode) error {
+	return db.update(func(dbs *DBStructure) error {
+		now := time.Now()
+		for k, v := range dbs.AuthorizationCodes {
+			if now.After(v.ExpiresAt) {
+				delete(dbs.AuthorizationCodes, k)
+			}
+		}
+		dbs.AuthorizationCodes[codeHash] = code
+		return nil
+	})
+}
+
+// Removes the code and returns it, so that every code can be exchanged at most once. Looking up and removing the code happen in one update, so concurrent exchanges can't both get it.
+func (db *DB) ConsumeAuthorizationCode(codeHash string) (AuthorizationCode, error) {
+	var code AuthorizationCode
+	err := db.update(func(dbs *DBStructure) error {
+		var exists bool
+		code, exists = dbs.AuthorizationCodes[codeHash]
+		if !exists {
+			return ErrInvalidAuthorizationCode
+		}
+		delete(dbs.AuthorizationCodes, codeHash)
+		return nil
+	})
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+	if time.Now().After(code.ExpiresAt) {
+		return AuthorizationCode{}, ErrInvalidAuthorizationCode
+	}
+	return code, nil
+}
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrTokenNotFound = errors.New("Personal access token not found")
+
+// How stale LastUsedAt may get before it is written back, so that every authenticated request doesn't rewrite the database
+const lastUsedResolution = time.Minute
+
+type personalAccessToken struct {
+	Id     int      `json:"id"`
+	UserId int      `json:"user_id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Only a hash of the token is stored, the token itself is shown to the user once on creation
+	Hash       string     `json:"hash"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type PersonalAccessToken struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func (t personalAccessToken) clean() PersonalAccessToken {
+	return PersonalAccessToken{Id: t.Id, UserId: t.UserId, Name: t.Name, Scopes: t.Scopes, CreatedAt: t.CreatedAt, ExpiresAt: t.ExpiresAt, LastUsedAt: t.LastUsedAt}
+}
+
+func (t personalAccessToken) expired(now time.Time) bool {
+	return t.ExpiresAt != nil && now.After(*t.ExpiresAt)
+}
+
+func (db *DB) CreatePersonalAccessToken(userId int, name string, scopes []string, hash string, expiresAt *time.Time) (PersonalAccessToken, error) {
+	var token personalAccessToken
+	err := db.update(func(dbs *DBStructure) error {
+		token = personalAccessToken{
+			Id:        dbs.NextPersonalAccessTokenId,
+			UserId:    userId,
+			Name:      name,
+			Scopes:    scopes,
+			Hash:      hash,
+			CreatedAt: time.Now(),
+			ExpiresAt: expiresAt,
+		}
+		dbs.NextPersonalAccessTokenId++
+		dbs.PersonalAccessTokens[token.Id] = token
+		return nil
+	})
+	return token.clean(), err
+}
+
+func (db *DB) GetPersonalAccessTokens(userId int) ([]PersonalAccessToken, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	tokens := []PersonalAccessToken{}
+	for _, t := range dbs.PersonalAccessTokens {
+		if t.UserId == userId {
+			tokens = append(tokens, t.clean())
+		}
+	}
+	slices.SortFunc(tokens, func(a, b PersonalAccessToken) int {
+		return a.Id - b.Id
+	})
+	return tokens, nil
+}
+
+// Deletes the token, provided that it belongs to the user.
+func (db *DB) RevokePersonalAccessToken(userId, id int) error {
+	return db.update(func(dbs *DBStructure) error {
+		token, exists := dbs.PersonalAccessTokens[id]
+		if !exists || token.UserId != userId {
+			return ErrTokenNotFound
+		}
+		delete(dbs.PersonalAccessTokens, id)
+		return nil
+	})
+}
+
+// Finds the unexpired token with the given hash, and records that it was used.
+func (db *DB) UsePersonalAccessToken(hash string) (PersonalAccessToken, error) {
+	var token personalAccessToken
+	err := db.update(func(dbs *DBStructure) error {
+		now := time.Now()
+		for id, t := range dbs.PersonalAccessTokens {
+			if t.Hash != hash {
+				continue
+			}
+			if t.expired(now) {
+				return errors.New("Personal access token has expired")
+			}
+			token = t
+			if t.LastUsedAt != nil && now.Sub(*t.LastUsedAt) <= lastUsedResolution {
+				return errNoChange
+			}
+			token.LastUsedAt = &now
+			dbs.PersonalAccessTokens[id] = token
+			return nil
+		}
+		return ErrTokenNotFound
+	})
+	if err != nil {
+		return PersonalAccessToken{}, err
+	}
+	return token.clean(), nil
+}
+
+// Finds the unexpired token with the given hash, without recording a use.
+func (db *DB) LookupPersonalAccessToken(hash string) (PersonalAccessToken, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return PersonalAccessToken{}, err
+	}
+	for _, t := range dbs.PersonalAccessTokens {
+		if t.Hash != hash {
+			continue
+		}
+		if t.expired(time.Now()) {
+			return PersonalAccessToken{}, errors.New("Personal access token has expired")
+		}
+		return t.clean(), nil
+	}
+	return PersonalAccessToken{}, ErrTokenNotFound
+}
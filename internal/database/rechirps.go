@@ -0,0 +1,85 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrQuotedNotFound = errors.New("Quoted chirp doesn't exist or was deleted")
+var ErrAlreadyRechirped = errors.New("Chirp has already been rechirped")
+var ErrRechirpNotFound = errors.New("Chirp hasn't been rechirped")
+
+// The id of the chirp that this one rechirps or quotes, if any.
+func (c Chirp) referenced() int {
+	if c.RechirpOf != 0 {
+		return c.RechirpOf
+	}
+	return c.QuoteOf
+}
+
+// Returns the chirp that likes and rechirps of the given one are meant for: the chirp itself, or the original if it is a rechirp, so that they aren't split between the original and its copies.
+func (dbs *DBStructure) actionTarget(chirpId int) (Chirp, error) {
+	chirp, exists := dbs.Chirps[chirpId]
+	if !exists || !dbs.chirpAvailable(chirp) {
+		return Chirp{}, ErrChirpNotFound
+	}
+	if chirp.RechirpOf == 0 {
+		return chirp, nil
+	}
+	original, exists := dbs.Chirps[chirp.RechirpOf]
+	if !exists || !dbs.chirpAvailable(original) {
+		return Chirp{}, ErrChirpNotFound
+	}
+	return original, nil
+}
+
+// Reposts the chirp on behalf of the user. Rechirping a rechirp reposts the original, and each user can only rechirp a chirp once, which is checked in the same update that stores the rechirp.
+func (db *DB) Rechirp(chirpId, userId int) (Chirp, error) {
+	var chirp Chirp
+	err := db.update(func(dbs *DBStructure) error {
+		original, err := dbs.actionTarget(chirpId)
+		if err != nil {
+			return err
+		}
+		chirpId = original.Id
+		if _, exists := dbs.findRechirp(chirpId, userId); exists {
+			return ErrAlreadyRechirped
+		}
+
+		chirp = Chirp{Id: dbs.NextChirpId, AuthorId: userId, CreatedAt: time.Now(), RechirpOf: chirpId}
+		dbs.NextChirpId++
+		dbs.Chirps[chirp.Id] = chirp
+		chirp = dbs.chirpDetails().fill(chirp)
+		return nil
+	})
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// Deletes the user's rechirp of the chirp.
+func (db *DB) Unrechirp(chirpId, userId int) error {
+	return db.update(func(dbs *DBStructure) error {
+		if original, exists := dbs.Chirps[chirpId]; exists && original.RechirpOf != 0 {
+			chirpId = original.RechirpOf
+		}
+		rechirp, exists := dbs.findRechirp(chirpId, userId)
+		if !exists {
+			return ErrRechirpNotFound
+		}
+		now := time.Now()
+		rechirp.DeletedAt = &now
+		dbs.Chirps[rechirp.Id] = rechirp
+		return nil
+	})
+}
+
+func (dbs *DBStructure) findRechirp(chirpId, userId int) (Chirp, bool) {
+	for _, c := range dbs.Chirps {
+		if c.RechirpOf == chirpId && c.AuthorId == userId && c.DeletedAt == nil {
+			return c, true
+		}
+	}
+	return Chirp{}, false
+}
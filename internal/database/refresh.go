@@ -0,0 +1,197 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("Session not found")
+
+// Every issued refresh token is tracked as a session, so that users can see where they are logged in and revoke sessions individually or all at once.
+type RefreshToken struct {
+	SessionId  string    `json:"session_id"`
+	UserId     int       `json:"user_id"`
+	IssuedAt   time.Time `json:"issued_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	// The OAuth client the session was granted to, if any
+	ClientId string `json:"client_id,omitempty"`
+}
+
+// A RefreshToken without the token itself, as shown to users.
+type Session struct {
+	Id         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	ClientId   string    `json:"client_id,omitempty"`
+}
+
+func (t RefreshToken) session() Session {
+	return Session{Id: t.SessionId, CreatedAt: t.IssuedAt, LastUsedAt: t.LastUsedAt, ExpiresAt: t.ExpiresAt, UserAgent: t.UserAgent, IP: t.IP, ClientId: t.ClientId}
+}
+
+func (db *DB) RecordRefreshToken(tokenString string, token RefreshToken) error {
+	return db.update(func(dbs *DBStructure) error {
+		dbs.pruneRefreshTokens(time.Now())
+		dbs.RefreshTokens[tokenString] = token
+		return nil
+	})
+}
+
+// Looks up the session of a refresh token without recording a use.
+func (db *DB) GetRefreshToken(tokenString string) (RefreshToken, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	token, exists := dbs.RefreshTokens[tokenString]
+	if !exists {
+		return RefreshToken{}, ErrSessionNotFound
+	}
+	return token, nil
+}
+
+// Looks up the session of a refresh token and records that it was used from the given client.
+func (db *DB) UseRefreshToken(tokenString, ip, userAgent string) (RefreshToken, error) {
+	var token RefreshToken
+	err := db.update(func(dbs *DBStructure) error {
+		var exists bool
+		token, exists = dbs.RefreshTokens[tokenString]
+		if !exists {
+			return ErrSessionNotFound
+		}
+		token.LastUsedAt = time.Now()
+		token.IP = ip
+		token.UserAgent = userAgent
+		dbs.RefreshTokens[tokenString] = token
+		return nil
+	})
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	return token, nil
+}
+
+// Whether the session still has an unexpired refresh token. Access tokens are only accepted while their session is active, so revoking a session cuts them off immediately.
+func (db *DB) SessionActive(sessionId string) (bool, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	for _, t := range dbs.RefreshTokens {
+		if t.SessionId == sessionId && now.Before(t.ExpiresAt) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Lists the user's unexpired sessions, most recently used first.
+func (db *DB) GetSessions(userId int) ([]Session, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	sessions := []Session{}
+	for _, t := range dbs.RefreshTokens {
+		if t.UserId == userId && now.Before(t.ExpiresAt) {
+			sessions = append(sessions, t.session())
+		}
+	}
+	slices.SortFunc(sessions, func(a, b Session) int {
+		return b.LastUsedAt.Compare(a.LastUsedAt)
+	})
+	return sessions, nil
+}
+
+// Revokes the refresh token of the session, provided that it belongs to the user.
+func (db *DB) RevokeSession(userId int, sessionId string) error {
+	return db.update(func(dbs *DBStructure) error {
+		for tokenString, t := range dbs.RefreshTokens {
+			if t.UserId == userId && t.SessionId == sessionId {
+				dbs.RevokedTokens[tokenString] = t.ExpiresAt
+				delete(dbs.RefreshTokens, tokenString)
+				return nil
+			}
+		}
+		return ErrSessionNotFound
+	})
+}
+
+// Moves every refresh token issued to the user onto the revocation list.
+func (db *DB) RevokeUserRefreshTokens(userId int) error {
+	return db.update(func(dbs *DBStructure) error {
+		dbs.revokeUserRefreshTokens(userId)
+		return nil
+	})
+}
+
+func (dbs *DBStructure) revokeUserRefreshTokens(userId int) {
+	dbs.pruneRefreshTokens(time.Now())
+	for tokenString, token := range dbs.RefreshTokens {
+		if token.UserId != userId {
+			continue
+		}
+		dbs.RevokedTokens[tokenString] = token.ExpiresAt
+		delete(dbs.RefreshTokens, tokenString)
+	}
+}
+
+// Forgets sessions and revocations of refresh tokens that have expired, since expired tokens are refused anyway. Called whenever sessions are recorded or revoked, so that the database doesn't keep every token ever issued.
+func (dbs *DBStructure) pruneRefreshTokens(now time.Time) {
+	for tokenString, t := range dbs.RefreshTokens {
+		if now.After(t.ExpiresAt) {
+			delete(dbs.RefreshTokens, tokenString)
+		}
+	}
+	for tokenString, expiresAt := range dbs.RevokedTokens {
+		if now.After(expiresAt) {
+			delete(dbs.RevokedTokens, tokenString)
+		}
+	}
+}
+
+// Tokens carry the generation of their user at the time they were issued, and are only accepted while it is still current.
+func (db *DB) GetTokenGeneration(userId int) (int, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return 0, err
+	}
+	user, exists := dbs.Users[userId]
+	if !exists {
+		return 0, errors.New("User with requested id doesn't exist")
+	}
+	return user.TokenGeneration, nil
+}
+
+// Revokes every refresh token and personal access token of the user, and invalidates all their outstanding access tokens by moving to a new token generation.
+func (db *DB) LogoutAll(userId int) error {
+	return db.update(func(dbs *DBStructure) error {
+		user, exists := dbs.Users[userId]
+		if !exists {
+			return errors.New("User with requested id doesn't exist")
+		}
+		dbs.logoutAll(user)
+		return nil
+	})
+}
+
+func (dbs *DBStructure) logoutAll(user user) {
+	user.TokenGeneration++
+	dbs.Users[user.Id] = user
+	dbs.revokeUserRefreshTokens(user.Id)
+	// Personal access tokens don't carry a generation, and one created by whoever got into the account must not outlive this
+	for id, t := range dbs.PersonalAccessTokens {
+		if t.UserId == user.Id {
+			delete(dbs.PersonalAccessTokens, id)
+		}
+	}
+}
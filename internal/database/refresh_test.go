@@ -0,0 +1,180 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestSession(t *testing.T, db *DB, tokenString string, userId int, expiresAt time.Time) {
+	t.Helper()
+	err := db.RecordRefreshToken(tokenString, RefreshToken{SessionId: "session-" + tokenString, UserId: userId, IssuedAt: time.Now(), ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevokeSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		userId  int
+		session string
+		want    error
+	}{
+		{"own session", 1, "session-a", nil},
+		{"other user's session", 2, "session-a", ErrSessionNotFound},
+		{"unknown session", 1, "session-x", ErrSessionNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			newTestSession(t, db, "a", 1, time.Now().Add(time.Hour))
+			newTestSession(t, db, "b", 1, time.Now().Add(time.Hour))
+
+			err := db.RevokeSession(tt.userId, tt.session)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("RevokeSession = %v, want %v", err, tt.want)
+			}
+			active, err := db.SessionActive("session-a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			revoked, err := db.TokenRevoked("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if active == (tt.want == nil) || revoked != (tt.want == nil) {
+				t.Errorf("Session active %v and token revoked %v after RevokeSession = %v", active, revoked, err)
+			}
+			if active, _ := db.SessionActive("session-b"); !active {
+				t.Error("Other session revoked as well")
+			}
+		})
+	}
+}
+
+func TestSessionActive(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		want      bool
+	}{
+		{"unexpired", time.Now().Add(time.Hour), true},
+		{"expired", time.Now().Add(-time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			newTestSession(t, db, "a", 1, tt.expiresAt)
+			if active, err := db.SessionActive("session-a"); err != nil || active != tt.want {
+				t.Errorf("SessionActive = %v, %v, want %v", active, err, tt.want)
+			}
+			sessions, err := db.GetSessions(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if listed := len(sessions) == 1; listed != tt.want {
+				t.Errorf("Sessions = %+v, want listed %v", sessions, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogoutAll(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "user@example.com")
+	other := newTestUser(t, db, "other@example.com")
+	newTestSession(t, db, "a", user.Id, time.Now().Add(time.Hour))
+	newTestSession(t, db, "b", other.Id, time.Now().Add(time.Hour))
+	for _, id := range []int{user.Id, other.Id} {
+		_, err := db.CreatePersonalAccessToken(id, "script", nil, fmt.Sprint("hash-", id), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	generation, err := db.GetTokenGeneration(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.LogoutAll(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  func() (bool, error)
+		want bool
+	}{
+		{"session active", func() (bool, error) { return db.SessionActive("session-a") }, false},
+		{"refresh token revoked", func() (bool, error) { return db.TokenRevoked("a") }, true},
+		{"other user's session active", func() (bool, error) { return db.SessionActive("session-b") }, true},
+		{"other user's refresh token revoked", func() (bool, error) { return db.TokenRevoked("b") }, false},
+		{"new token generation", func() (bool, error) {
+			g, err := db.GetTokenGeneration(user.Id)
+			return g > generation, err
+		}, true},
+		{"personal access tokens left", func() (bool, error) {
+			pats, err := db.GetPersonalAccessTokens(user.Id)
+			return len(pats) > 0, err
+		}, false},
+		{"other user's personal access tokens left", func() (bool, error) {
+			pats, err := db.GetPersonalAccessTokens(other.Id)
+			return len(pats) > 0, err
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.got()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPruneRefreshTokens(t *testing.T) {
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	db := newTestDB(t)
+	newTestSession(t, db, "expired", 1, past)
+	newTestSession(t, db, "revoked", 1, future)
+	err := db.RevokeToken("revoked", future)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.RevokeToken("revoked-expired", past)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Recording a new session prunes everything that has expired
+	newTestSession(t, db, "live", 1, future)
+
+	dbs, err := db.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		token   string
+		session bool
+		revoked bool
+	}{
+		{"expired", false, false},
+		{"revoked", false, true},
+		{"revoked-expired", false, false},
+		{"live", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.token, func(t *testing.T) {
+			_, session := dbs.RefreshTokens[tt.token]
+			_, revoked := dbs.RevokedTokens[tt.token]
+			if session != tt.session || revoked != tt.revoked {
+				t.Errorf("Kept as session %v and revocation %v, want %v and %v", session, revoked, tt.session, tt.revoked)
+			}
+		})
+	}
+}
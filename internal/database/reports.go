@@ -0,0 +1,268 @@
+package database
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+var ErrReportNotFound = errors.New("Report doesn't exist")
+var ErrAlreadyReported = errors.New("Chirp has already been reported by this user")
+var ErrReportClaimed = errors.New("Report has been claimed by another moderator")
+var ErrReportNotClaimed = errors.New("Report must be claimed before it can be resolved")
+var ErrReportResolved = errors.New("Report has already been resolved")
+var ErrAuthorBanned = errors.New("Author is already banned")
+
+const ReportOpen = "open"
+const ReportClaimed = "claimed"
+const ReportResolved = "resolved"
+
+const ResolutionDismiss = "dismiss"
+const ResolutionDeleteChirp = "delete_chirp"
+const ResolutionSuspendAuthor = "suspend_author"
+
+// Reports come from users, or from the moderation filter flagging a chirp
+const ReportSourceUser = "user"
+const ReportSourceFilter = "filter"
+
+// Claims that are left alone this long can be taken over by other moderators
+const claimTimeout = 30 * time.Minute
+
+type Report struct {
+	Id      int    `json:"id"`
+	ChirpId int    `json:"chirp_id"`
+	Source  string `json:"source"`
+	// 0 for reports by the moderation filter
+	ReporterId int       `json:"reporter_id,omitempty"`
+	Reason     string    `json:"reason"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	ClaimedBy  int        `json:"claimed_by,omitempty"`
+	ClaimedAt  *time.Time `json:"claimed_at,omitempty"`
+	Resolution string     `json:"resolution,omitempty"`
+	ResolvedBy int        `json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	Note       string     `json:"note,omitempty"`
+
+	// Filled in when listing reports for moderators
+	Chirp *Chirp `json:"chirp,omitempty"`
+}
+
+func (r Report) claimedByOther(moderatorId int, now time.Time) bool {
+	return r.Status == ReportClaimed && r.ClaimedBy != moderatorId && now.Sub(*r.ClaimedAt) < claimTimeout
+}
+
+// Something a moderator or admin did, kept so that moderation can be reviewed later.
+type ModerationAction struct {
+	Id int `json:"id"`
+	// The moderator who acted, or 0 for an admin
+	ActorId int       `json:"actor_id"`
+	Action  string    `json:"action"`
+	At      time.Time `json:"at"`
+	// What was acted on, depending on the action
+	ReportId int    `json:"report_id,omitempty"`
+	ChirpId  int    `json:"chirp_id,omitempty"`
+	UserId   int    `json:"user_id,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// Must be called within the update that makes the changes, so that the action is recorded along with them.
+func (dbs *DBStructure) audit(action ModerationAction) {
+	action.Id = len(dbs.ModerationLog) + 1
+	action.At = time.Now()
+	dbs.ModerationLog = append(dbs.ModerationLog, action)
+}
+
+// Must be called within an update.
+func (dbs *DBStructure) addReport(report Report) Report {
+	report.Id = dbs.NextReportId
+	dbs.NextReportId++
+	report.Status = ReportOpen
+	report.CreatedAt = time.Now()
+	dbs.Reports[report.Id] = report
+	return report
+}
+
+// Users can have one report of a chirp awaiting a decision at a time.
+func (db *DB) ReportChirp(chirpId, reporterId int, reason string) (Report, error) {
+	var report Report
+	err := db.update(func(dbs *DBStructure) error {
+		chirp, exists := dbs.Chirps[chirpId]
+		if !exists || !dbs.chirpAvailable(chirp) {
+			return ErrChirpNotFound
+		}
+		for _, r := range dbs.Reports {
+			if r.ChirpId == chirpId && r.ReporterId == reporterId && r.Status != ReportResolved {
+				return ErrAlreadyReported
+			}
+		}
+		report = dbs.addReport(Report{ChirpId: chirpId, Source: ReportSourceUser, ReporterId: reporterId, Reason: reason})
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+// Returns the reports with the status, or every report if the status is empty, oldest first.
+func (db *DB) GetReports(status string) ([]Report, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	details := dbs.chirpDetails()
+	reports := []Report{}
+	for _, r := range dbs.Reports {
+		if status != "" && r.Status != status {
+			continue
+		}
+		// Moderators need to see what was reported even if it has been deleted since
+		if chirp, exists := dbs.Chirps[r.ChirpId]; exists {
+			chirp = details.fill(chirp)
+			r.Chirp = &chirp
+		}
+		reports = append(reports, r)
+	}
+	slices.SortFunc(reports, func(a, b Report) int {
+		return a.Id - b.Id
+	})
+	return reports, nil
+}
+
+// Assigns the report to the moderator, so that others know it is being looked at. The claim is checked and taken in one update, so two moderators can't both get it.
+func (db *DB) ClaimReport(reportId, moderatorId int) (Report, error) {
+	var report Report
+	err := db.update(func(dbs *DBStructure) error {
+		var exists bool
+		report, exists = dbs.Reports[reportId]
+		if !exists {
+			return ErrReportNotFound
+		}
+		now := time.Now()
+		if report.Status == ReportResolved {
+			return ErrReportResolved
+		}
+		if report.claimedByOther(moderatorId, now) {
+			return ErrReportClaimed
+		}
+		report.Status = ReportClaimed
+		report.ClaimedBy = moderatorId
+		report.ClaimedAt = &now
+		dbs.Reports[reportId] = report
+		dbs.audit(ModerationAction{ActorId: moderatorId, Action: "claim_report", ReportId: reportId, ChirpId: report.ChirpId})
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+// Decides a claimed report. Deleting the chirp or suspending its author also settles every other open report of the chirp, as does dismissing it. The suspension, if any, lasts until the given time, and authors who are already banned can't be suspended.
+func (db *DB) ResolveReport(reportId, moderatorId int, resolution, note string, suspendUntil time.Time) (Report, error) {
+	var report Report
+	err := db.update(func(dbs *DBStructure) error {
+		var exists bool
+		report, exists = dbs.Reports[reportId]
+		if !exists {
+			return ErrReportNotFound
+		}
+		now := time.Now()
+		if report.Status == ReportResolved {
+			return ErrReportResolved
+		}
+		if report.Status != ReportClaimed || report.ClaimedBy != moderatorId {
+			if report.claimedByOther(moderatorId, now) {
+				return ErrReportClaimed
+			}
+			return ErrReportNotClaimed
+		}
+
+		chirp, exists := dbs.Chirps[report.ChirpId]
+		if !exists {
+			return ErrChirpNotFound
+		}
+		action := ModerationAction{ActorId: moderatorId, Action: resolution, ReportId: reportId, ChirpId: report.ChirpId, Note: note}
+		switch resolution {
+		case ResolutionDeleteChirp:
+			if chirp.DeletedAt == nil {
+				chirp.DeletedAt = &now
+				dbs.Chirps[chirp.Id] = chirp
+			}
+		case ResolutionSuspendAuthor:
+			author, exists := dbs.Users[chirp.AuthorId]
+			if !exists {
+				return ErrUserNotFound
+			}
+			// A suspension would replace the ban and lift it once it runs out
+			if author.Sanction.banned() {
+				return ErrAuthorBanned
+			}
+			author.Sanction = &Sanction{Kind: SanctionSuspended, Reason: note, CreatedAt: now, Until: &suspendUntil}
+			dbs.Users[author.Id] = author
+			action.UserId = author.Id
+		}
+
+		for id, r := range dbs.Reports {
+			if r.ChirpId != report.ChirpId || r.Status == ReportResolved {
+				continue
+			}
+			r.Status = ReportResolved
+			r.Resolution = resolution
+			r.ResolvedBy = moderatorId
+			r.ResolvedAt = &now
+			r.Note = note
+			dbs.Reports[id] = r
+		}
+		dbs.audit(action)
+		report = dbs.Reports[reportId]
+		return nil
+	})
+	if err != nil {
+		return Report{}, err
+	}
+	return report, nil
+}
+
+func (db *DB) IsModerator(userId int) (bool, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return false, err
+	}
+	user, exists := dbs.Users[userId]
+	if !exists {
+		return false, ErrUserNotFound
+	}
+	return user.IsModerator, nil
+}
+
+// Grants or revokes moderator rights. Only admins can do this, so it is recorded as an admin action.
+func (db *DB) SetModerator(userId int, moderator bool) error {
+	return db.update(func(dbs *DBStructure) error {
+		user, exists := dbs.Users[userId]
+		if !exists {
+			return ErrUserNotFound
+		}
+		user.IsModerator = moderator
+		dbs.Users[userId] = user
+		action := "revoke_moderator"
+		if moderator {
+			action = "grant_moderator"
+		}
+		dbs.audit(ModerationAction{Action: action, UserId: userId})
+		return nil
+	})
+}
+
+// Returns every recorded moderation action, newest first.
+func (db *DB) GetModerationLog() ([]ModerationAction, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	actions := slices.Clone(dbs.ModerationLog)
+	slices.Reverse(actions)
+	return actions, nil
+}
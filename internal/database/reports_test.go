@@ -0,0 +1,206 @@
+package database
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Users 1 and 2 are moderators and 3 is the author of chirp 1, which has been reported by user 4.
+func newTestReport(t *testing.T) (*DB, Report) {
+	t.Helper()
+	db := newTestDB(t)
+	for _, email := range []string{"mod1@example.com", "mod2@example.com", "author@example.com", "reporter@example.com"} {
+		newTestUser(t, db, email)
+	}
+	chirp := newTestChirp(t, db, 3, 0)
+	report, err := db.ReportChirp(chirp.Id, 4, "spam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, report
+}
+
+// Changes the report directly, to set up states such as claims made long ago.
+func setReport(t *testing.T, db *DB, id int, change func(r *Report)) {
+	t.Helper()
+	err := db.update(func(dbs *DBStructure) error {
+		r := dbs.Reports[id]
+		change(&r)
+		dbs.Reports[id] = r
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func claimedAgo(moderatorId int, ago time.Duration) func(r *Report) {
+	return func(r *Report) {
+		at := time.Now().Add(-ago)
+		r.Status = ReportClaimed
+		r.ClaimedBy = moderatorId
+		r.ClaimedAt = &at
+	}
+}
+
+func TestClaimReport(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(r *Report)
+		want  error
+	}{
+		{"open", func(r *Report) {}, nil},
+		{"claimed by the same moderator", claimedAgo(1, time.Minute), nil},
+		{"claimed by another moderator", claimedAgo(2, time.Minute), ErrReportClaimed},
+		{"claim about to expire", claimedAgo(2, claimTimeout-time.Minute), ErrReportClaimed},
+		{"claim expired", claimedAgo(2, claimTimeout+time.Minute), nil},
+		{"resolved", func(r *Report) { r.Status = ReportResolved }, ErrReportResolved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, report := newTestReport(t)
+			setReport(t, db, report.Id, tt.setup)
+
+			claimed, err := db.ClaimReport(report.Id, 1)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("ClaimReport = %v, want %v", err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			if claimed.Status != ReportClaimed || claimed.ClaimedBy != 1 || claimed.ClaimedAt == nil || time.Since(*claimed.ClaimedAt) > time.Minute {
+				t.Errorf("Claimed report = %+v, want a fresh claim by moderator 1", claimed)
+			}
+		})
+	}
+
+	t.Run("missing report", func(t *testing.T) {
+		db, _ := newTestReport(t)
+		if _, err := db.ClaimReport(100, 1); !errors.Is(err, ErrReportNotFound) {
+			t.Errorf("ClaimReport = %v, want ErrReportNotFound", err)
+		}
+	})
+}
+
+func TestResolveReportClaims(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(r *Report)
+		want  error
+	}{
+		{"claimed", claimedAgo(1, time.Minute), nil},
+		// Resolving takes no new claim, so an expired claim of one's own still counts
+		{"own claim expired", claimedAgo(1, claimTimeout+time.Minute), nil},
+		{"open", func(r *Report) {}, ErrReportNotClaimed},
+		{"claimed by another moderator", claimedAgo(2, time.Minute), ErrReportClaimed},
+		{"claim of another moderator expired", claimedAgo(2, claimTimeout+time.Minute), ErrReportNotClaimed},
+		{"resolved", func(r *Report) { r.Status = ReportResolved }, ErrReportResolved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, report := newTestReport(t)
+			setReport(t, db, report.Id, tt.setup)
+
+			_, err := db.ResolveReport(report.Id, 1, ResolutionDismiss, "", time.Time{})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("ResolveReport = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveReport(t *testing.T) {
+	until := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name        string
+		resolution  string
+		banned      bool
+		want        error
+		deleted     bool
+		sanctioned  string
+		auditedUser int
+	}{
+		{"dismiss", ResolutionDismiss, false, nil, false, "", 0},
+		{"delete chirp", ResolutionDeleteChirp, false, nil, true, "", 0},
+		{"suspend author", ResolutionSuspendAuthor, false, nil, false, SanctionSuspended, 3},
+		{"suspend banned author", ResolutionSuspendAuthor, true, ErrAuthorBanned, false, SanctionBanned, 0},
+		{"delete chirp of banned author", ResolutionDeleteChirp, true, nil, true, SanctionBanned, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, report := newTestReport(t)
+			// Another report of the same chirp, which is settled along with the first
+			other, err := db.ReportChirp(report.ChirpId, 2, "abuse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.banned {
+				err = db.SanctionUser(3, Sanction{Kind: SanctionBanned, CreatedAt: time.Now()})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			_, err = db.ClaimReport(report.Id, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resolved, err := db.ResolveReport(report.Id, 1, tt.resolution, "note", until)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("ResolveReport = %v, want %v", err, tt.want)
+			}
+
+			dbs, err := db.load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted := dbs.Chirps[report.ChirpId].DeletedAt != nil; deleted != tt.deleted {
+				t.Errorf("Chirp deleted = %v, want %v", deleted, tt.deleted)
+			}
+			sanction := dbs.Users[3].Sanction
+			if tt.sanctioned == "" && sanction != nil || tt.sanctioned != "" && (sanction == nil || sanction.Kind != tt.sanctioned) {
+				t.Errorf("Author sanction = %+v, want %q", sanction, tt.sanctioned)
+			}
+			if tt.sanctioned == SanctionSuspended && (sanction.Until == nil || !sanction.Until.Equal(until)) {
+				t.Errorf("Suspended until %v, want %v", sanction.Until, until)
+			}
+
+			if tt.want != nil {
+				// Nothing changes when the resolution is refused
+				if dbs.Reports[report.Id].Status != ReportClaimed || dbs.Reports[other.Id].Status != ReportOpen {
+					t.Errorf("Reports changed by a refused resolution: %+v", dbs.Reports)
+				}
+				return
+			}
+			if resolved.Status != ReportResolved || resolved.Resolution != tt.resolution || resolved.ResolvedBy != 1 || resolved.Note != "note" {
+				t.Errorf("Resolved report = %+v", resolved)
+			}
+			if r := dbs.Reports[other.Id]; r.Status != ReportResolved || r.Resolution != tt.resolution {
+				t.Errorf("Other report of the chirp = %+v, want it resolved as well", r)
+			}
+			last := dbs.ModerationLog[len(dbs.ModerationLog)-1]
+			if last.Action != tt.resolution || last.ActorId != 1 || last.ReportId != report.Id || last.UserId != tt.auditedUser {
+				t.Errorf("Audited %+v", last)
+			}
+		})
+	}
+}
+
+func TestResolveReportMissingChirp(t *testing.T) {
+	db, report := newTestReport(t)
+	_, err := db.ClaimReport(report.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.update(func(dbs *DBStructure) error {
+		delete(dbs.Chirps, report.ChirpId)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ResolveReport(report.Id, 1, ResolutionDismiss, "", time.Time{}); !errors.Is(err, ErrChirpNotFound) {
+		t.Errorf("ResolveReport = %v, want ErrChirpNotFound", err)
+	}
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidResetToken = errors.New("Password reset token is invalid or expired")
+
+// Only a hash of the reset token is stored, since the token itself grants control of the account.
+type PasswordReset struct {
+	UserId    int       `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Stores a new reset token for the user, replacing any earlier ones.
+func (db *DB) CreatePasswordReset(tokenHash string, reset PasswordReset) error {
+	return db.update(func(dbs *DBStructure) error {
+		now := time.Now()
+		for k, v := range dbs.PasswordResets {
+			if v.UserId == reset.UserId || now.After(v.ExpiresAt) {
+				delete(dbs.PasswordResets, k)
+			}
+		}
+		dbs.PasswordResets[tokenHash] = reset
+		return nil
+	})
+}
+
+// Returns the user that the reset token was issued to, without consuming the token.
+func (db *DB) GetPasswordResetUser(tokenHash string) (SafeUser, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return SafeUser{}, err
+	}
+	reset, ok := dbs.PasswordResets[tokenHash]
+	if !ok || time.Now().After(reset.ExpiresAt) {
+		return SafeUser{}, ErrInvalidResetToken
+	}
+	user, exists := dbs.Users[reset.UserId]
+	if !exists {
+		return SafeUser{}, ErrInvalidResetToken
+	}
+	return user.clean(), nil
+}
+
+// Consumes the reset token, sets the new password and logs the user out everywhere. The token is checked and consumed in one update, so it can only be used once even by concurrent requests.
+func (db *DB) ResetPassword(tokenHash, password string) (SafeUser, error) {
+	hash, err := db.hasher.Hash(password)
+	if err != nil {
+		return SafeUser{}, err
+	}
+	var user user
+	err = db.update(func(dbs *DBStructure) error {
+		reset, ok := dbs.PasswordResets[tokenHash]
+		if !ok || time.Now().After(reset.ExpiresAt) {
+			return ErrInvalidResetToken
+		}
+		delete(dbs.PasswordResets, tokenHash)
+
+		var exists bool
+		user, exists = dbs.Users[reset.UserId]
+		if !exists {
+			return ErrInvalidResetToken
+		}
+		user.Hash = hash
+		// The reset token was delivered to the user's address, which proves they control it
+		user.EmailVerified = true
+		// Whoever knew the old password may still be logged in
+		dbs.logoutAll(user)
+		return nil
+	})
+	if err != nil {
+		return SafeUser{}, err
+	}
+	return user.clean(), nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/entities"
+)
+
+// An earlier body of an edited chirp, along with when it was posted and when it was replaced.
+type ChirpRevision struct {
+	Body       string    `json:"body"`
+	PostedAt   time.Time `json:"posted_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// Replaces the body of the chirp and the entities found in it, keeping the previous body as a revision.
+func (db *DB) EditChirp(id int, body string, ents []entities.Entity) (Chirp, error) {
+	var edited Chirp
+	err := db.update(func(dbs *DBStructure) error {
+		chirp, exists := dbs.Chirps[id]
+		if !exists || !dbs.chirpAvailable(chirp) {
+			return ErrChirpNotFound
+		}
+
+		now := time.Now()
+		postedAt := chirp.CreatedAt
+		if chirp.EditedAt != nil {
+			postedAt = *chirp.EditedAt
+		}
+		dbs.ChirpRevisions[id] = append(dbs.ChirpRevisions[id], ChirpRevision{Body: chirp.Body, PostedAt: postedAt, ReplacedAt: now})
+
+		chirp.Body = body
+		chirp.Entities = ents
+		chirp.EditedAt = &now
+		chirp.RevisionCount = len(dbs.ChirpRevisions[id])
+		dbs.Chirps[id] = chirp
+		edited = dbs.chirpDetails().fill(chirp)
+		return nil
+	})
+	return edited, err
+}
+
+// Returns the earlier bodies of the chirp, oldest first.
+func (db *DB) GetChirpRevisions(id int) ([]ChirpRevision, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	chirp, exists := dbs.Chirps[id]
+	if !exists || !dbs.chirpAvailable(chirp) {
+		return nil, ErrChirpNotFound
+	}
+	revisions := dbs.ChirpRevisions[id]
+	if revisions == nil {
+		revisions = []ChirpRevision{}
+	}
+	return revisions, nil
+}
@@ -0,0 +1,75 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrUserNotFound = errors.New("User with requested id doesn't exist")
+
+const SanctionSuspended = "suspended"
+const SanctionBanned = "banned"
+
+// Stops a user from logging in or using any of their tokens, either until a set time or, for bans, permanently.
+type Sanction struct {
+	Kind   string `json:"kind"`
+	Reason string `json:"reason"`
+	// Shown to the user along with the reason, for example how to appeal
+	AppealNote string     `json:"appeal_note,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Until      *time.Time `json:"until,omitempty"`
+}
+
+func (s *Sanction) active(now time.Time) bool {
+	return s != nil && (s.Until == nil || now.Before(*s.Until))
+}
+
+func (s *Sanction) banned() bool {
+	return s != nil && s.Kind == SanctionBanned
+}
+
+func (db *DB) SanctionUser(userId int, sanction Sanction) error {
+	return db.update(func(dbs *DBStructure) error {
+		user, exists := dbs.Users[userId]
+		if !exists {
+			return ErrUserNotFound
+		}
+		user.Sanction = &sanction
+		dbs.Users[userId] = user
+		return nil
+	})
+}
+
+// Lifts any suspension or ban. Returns ErrUserNotFound if the user doesn't exist.
+func (db *DB) LiftSanction(userId int) error {
+	return db.update(func(dbs *DBStructure) error {
+		user, exists := dbs.Users[userId]
+		if !exists {
+			return ErrUserNotFound
+		}
+		user.Sanction = nil
+		dbs.Users[userId] = user
+		return nil
+	})
+}
+
+// Returns the user's sanction if it is in effect, or nil.
+func (db *DB) GetSanction(userId int) (*Sanction, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	user, exists := dbs.Users[userId]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	if !user.Sanction.active(time.Now()) {
+		return nil, nil
+	}
+	return user.Sanction, nil
+}
+
+// Chirps by banned users are hidden everywhere. Suspended users' chirps stay up.
+func (dbs *DBStructure) chirpVisible(chirp Chirp) bool {
+	return !dbs.Users[chirp.AuthorId].Sanction.banned()
+}
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/madsbv/go-server-exercise/internal/entities"
+)
+
+var ErrChirpNotFound = errors.New("Chirp with requested id doesn't exist")
+var ErrParentNotFound = errors.New("Chirp being replied to doesn't exist or was deleted")
+
+// A chirp in a conversation, along with the replies to it.
+type ThreadNode struct {
+	Chirp   Chirp        `json:"chirp"`
+	Replies []ThreadNode `json:"replies"`
+	// Set when the depth limit cut off further replies, which can be fetched with a thread starting at this chirp
+	MoreReplies bool `json:"more_replies,omitempty"`
+}
+
+// Whether the chirp may be shown at all, as opposed to being replaced by a tombstone.
+func (dbs *DBStructure) chirpAvailable(chirp Chirp) bool {
+	return chirp.DeletedAt == nil && dbs.chirpVisible(chirp)
+}
+
+// Keeps the chirp's place in a conversation without revealing anything about it.
+func (c Chirp) tombstone() Chirp {
+	return Chirp{Id: c.Id, InReplyTo: c.InReplyTo, CreatedAt: c.CreatedAt, Tombstone: true, Entities: []entities.Entity{}}
+}
+
+// Returns the available direct replies to the chirp, oldest first.
+func (db *DB) GetChirpReplies(id int) ([]Chirp, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	chirp, exists := dbs.Chirps[id]
+	if !exists || !dbs.chirpVisible(chirp) {
+		return nil, ErrChirpNotFound
+	}
+	details := dbs.chirpDetails()
+	replies := []Chirp{}
+	for _, c := range dbs.Chirps {
+		if c.InReplyTo == id && dbs.chirpAvailable(c) {
+			replies = append(replies, details.fill(c))
+		}
+	}
+	slices.SortFunc(replies, func(a, b Chirp) int {
+		return a.Id - b.Id
+	})
+	return replies, nil
+}
+
+// Returns the conversation around the chirp: the chain of chirps it replies to, back to the one that began the conversation, and the replies below it going at most maxDepth replies deep. The chain leaves out other replies to the chirps on it, which are marked with MoreReplies. Deleted and hidden chirps appear as tombstones where they have replies, and are left out otherwise.
+func (db *DB) GetThread(id, maxDepth int) (ThreadNode, error) {
+	dbs, err := db.load()
+	if err != nil {
+		return ThreadNode{}, err
+	}
+	chirp, exists := dbs.Chirps[id]
+	if !exists || !dbs.chirpAvailable(chirp) {
+		return ThreadNode{}, ErrChirpNotFound
+	}
+
+	children := make(map[int][]Chirp)
+	for _, c := range dbs.Chirps {
+		if c.InReplyTo != 0 {
+			children[c.InReplyTo] = append(children[c.InReplyTo], c)
+		}
+	}
+	for _, cs := range children {
+		slices.SortFunc(cs, func(a, b Chirp) int {
+			return a.Id - b.Id
+		})
+	}
+
+	// The depth limit counts from the requested chirp, so that it is never cut off itself however deep in the conversation it is
+	details := dbs.chirpDetails()
+	node, _ := dbs.threadNode(chirp, children, details, 0, maxDepth)
+	for chirp.InReplyTo != 0 {
+		parent, exists := dbs.Chirps[chirp.InReplyTo]
+		if !exists {
+			break
+		}
+		node = ThreadNode{
+			Chirp:       dbs.threadChirp(parent, details),
+			Replies:     []ThreadNode{node},
+			MoreReplies: len(children[parent.Id]) > 1,
+		}
+		chirp = parent
+	}
+	return node, nil
+}
+
+// Builds the subtree below the chirp. Returns false if nothing in it can be shown.
+func (dbs *DBStructure) threadNode(chirp Chirp, children map[int][]Chirp, details chirpDetails, depth, maxDepth int) (ThreadNode, bool) {
+	node := ThreadNode{Replies: []ThreadNode{}}
+	if depth >= maxDepth {
+		node.MoreReplies = len(children[chirp.Id]) > 0
+	} else {
+		for _, c := range children[chirp.Id] {
+			if child, ok := dbs.threadNode(c, children, details, depth+1, maxDepth); ok {
+				node.Replies = append(node.Replies, child)
+			}
+		}
+	}
+
+	node.Chirp = dbs.threadChirp(chirp, details)
+	// The requested chirp is always shown, so that the thread has somewhere to start
+	return node, !node.Chirp.Tombstone || depth == 0 || len(node.Replies) > 0 || node.MoreReplies
+}
+
+// Fills in the chirp for display in a thread, or replaces it by a tombstone if it can't be shown.
+func (dbs *DBStructure) threadChirp(chirp Chirp, details chirpDetails) Chirp {
+	if dbs.chirpAvailable(chirp) {
+		return details.fill(chirp)
+	}
+	tombstone := chirp.tombstone()
+	tombstone.ReplyCount = details.replies[chirp.Id]
+	return tombstone
+}
@@ -0,0 +1,135 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/madsbv/go-server-exercise/internal/password"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"), password.NewScheme(password.Bcrypt{Cost: bcrypt.MinCost}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestUser(t *testing.T, db *DB, email string) SafeUser {
+	t.Helper()
+	user, err := db.CreateUser(email, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func newTestChirp(t *testing.T, db *DB, authorId, inReplyTo int) Chirp {
+	t.Helper()
+	chirp, err := db.CreateChirp(Chirp{Body: "chirp", AuthorId: authorId, InReplyTo: inReplyTo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chirp
+}
+
+// Lists the chirps of the thread depth first, marking tombstones with x and chirps with more replies than shown with +.
+func flattenThread(node ThreadNode) []string {
+	s := fmt.Sprint(node.Chirp.Id)
+	if node.Chirp.Tombstone {
+		s += "x"
+	}
+	if node.MoreReplies {
+		s += "+"
+	}
+	ids := []string{s}
+	for _, r := range node.Replies {
+		ids = append(ids, flattenThread(r)...)
+	}
+	return ids
+}
+
+func TestGetThread(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "user@example.com")
+	// Chirps 1 to 25 each reply to the one before, 26 is another reply to 1 and 27 another reply to 12
+	for i := 1; i <= 25; i++ {
+		newTestChirp(t, db, user.Id, i-1)
+	}
+	newTestChirp(t, db, user.Id, 1)
+	newTestChirp(t, db, user.Id, 12)
+
+	tests := []struct {
+		name     string
+		id       int
+		maxDepth int
+		want     []string
+	}{
+		{"from the start", 1, 3, []string{"1", "2", "3", "4+", "26"}},
+		{"replies of the start are all shown", 1, 1, []string{"1", "2+", "26"}},
+		{"one reply deep", 2, 2, []string{"1+", "2", "3", "4+"}},
+		// Deeper than the limit from the start of the conversation, so the limit has to count from the requested chirp
+		{"deeper than the limit", 12, 10, []string{"1+", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13", "14", "15", "16", "17", "18", "19", "20", "21", "22+", "27"}},
+		{"deeper than the limit with a small limit", 20, 2, []string{"1+", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12+", "13", "14", "15", "16", "17", "18", "19", "20", "21", "22+"}},
+		{"last reply", 25, 10, []string{"1+", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12+", "13", "14", "15", "16", "17", "18", "19", "20", "21", "22", "23", "24", "25"}},
+		{"side reply", 27, 10, []string{"1+", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12+", "27"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thread, err := db.GetThread(tt.id, tt.maxDepth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := flattenThread(thread); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetThread(%d, %d) = %v, want %v", tt.id, tt.maxDepth, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetThreadDeleted(t *testing.T) {
+	db := newTestDB(t)
+	user := newTestUser(t, db, "user@example.com")
+	for i := 1; i <= 5; i++ {
+		newTestChirp(t, db, user.Id, i-1)
+	}
+	// A reply without replies of its own
+	newTestChirp(t, db, user.Id, 4)
+	for _, id := range []int{2, 6} {
+		err := db.DeleteChirp(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		id   int
+		want []string
+		err  error
+	}{
+		{"deleted chirps with replies become tombstones", 1, []string{"1", "2x", "3", "4", "5"}, nil},
+		{"tombstones in the chain", 4, []string{"1", "2x", "3", "4", "5"}, nil},
+		{"deleted chirp", 2, nil, ErrChirpNotFound},
+		{"missing chirp", 100, nil, ErrChirpNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thread, err := db.GetThread(tt.id, 10)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("GetThread error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if got := flattenThread(thread); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetThread(%d) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+}
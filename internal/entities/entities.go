@@ -0,0 +1,95 @@
+// Package entities finds the hashtags, mentions and URLs in chirp bodies, so that clients can link them without parsing bodies themselves.
+package entities
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const TypeHashtag = "hashtag"
+const TypeMention = "mention"
+const TypeURL = "url"
+
+// A piece of a chirp body with a meaning of its own.
+type Entity struct {
+	Type string `json:"type"`
+	// Offsets into the body in runes (Unicode code points), with the end exclusive. They include the leading '#' or '@'.
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// The lowercased tag without '#', the mentioned email address without '@', or the URL
+	Value string `json:"value"`
+	// The mentioned user, filled in by the caller since this package knows nothing about users
+	UserId int `json:"user_id,omitempty"`
+}
+
+// Only http(s) URLs are recognized, and they run until the next whitespace. Punctuation at the end is more likely to end the sentence than the URL.
+var urlPattern = regexp.MustCompile(`https?://\S+`)
+
+const urlTrailingPunctuation = `.,;:!?'")]}`
+
+// Users have no handles, so they are mentioned by email address. The sigils must not follow a word character, so that neither email addresses nor words like "C#" count.
+var hashtagPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&])#([\p{L}\p{N}_]+)`)
+var mentionPattern = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@.])@([\p{L}\p{N}._%+-]+@[\p{L}\p{N}-]+(?:\.[\p{L}\p{N}-]+)+)`)
+
+// Returns the entities in the body, ordered by where they start. URLs take precedence, so that for example the fragment of a URL isn't taken for a hashtag.
+func Extract(body string) []Entity {
+	ents := []Entity{}
+	// Byte offsets, which are converted to runes at the end
+	type span struct{ start, end int }
+	taken := []span{}
+	overlaps := func(start, end int) bool {
+		for _, s := range taken {
+			if start < s.end && s.start < end {
+				return true
+			}
+		}
+		return false
+	}
+	add := func(typ string, start, end int, value string) {
+		taken = append(taken, span{start, end})
+		ents = append(ents, Entity{Type: typ, Start: start, End: end, Value: value})
+	}
+
+	for _, m := range urlPattern.FindAllStringIndex(body, -1) {
+		start, end := m[0], m[1]
+		for end > start && strings.ContainsRune(urlTrailingPunctuation, rune(body[end-1])) {
+			end--
+		}
+		if strings.HasSuffix(body[start:end], "://") {
+			continue
+		}
+		add(TypeURL, start, end, body[start:end])
+	}
+	// The submatch starts after the sigil, which comes right before it
+	for _, m := range mentionPattern.FindAllStringSubmatchIndex(body, -1) {
+		start, end := m[2]-1, m[3]
+		if !overlaps(start, end) {
+			add(TypeMention, start, end, body[m[2]:m[3]])
+		}
+	}
+	for _, m := range hashtagPattern.FindAllStringSubmatchIndex(body, -1) {
+		start, end := m[2]-1, m[3]
+		tag := body[m[2]:m[3]]
+		// Hashtags of only digits are usually numbers, as in "issue #12"
+		if strings.Trim(tag, "0123456789") == "" || overlaps(start, end) {
+			continue
+		}
+		add(TypeHashtag, start, end, NormalizeHashtag(tag))
+	}
+
+	slices.SortFunc(ents, func(a, b Entity) int {
+		return a.Start - b.Start
+	})
+	for i, e := range ents {
+		ents[i].Start = utf8.RuneCountInString(body[:e.Start])
+		ents[i].End = ents[i].Start + utf8.RuneCountInString(body[e.Start:e.End])
+	}
+	return ents
+}
+
+// Hashtags match regardless of case and of a leading '#'.
+func NormalizeHashtag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
@@ -0,0 +1,84 @@
+package entities
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Entity
+	}{
+		{"none", "just some words", []Entity{}},
+		{"hashtag", "#go is fun", []Entity{{Type: TypeHashtag, Start: 0, End: 3, Value: "go"}}},
+		{"hashtag is lowercased", "I like #GoLang", []Entity{{Type: TypeHashtag, Start: 7, End: 14, Value: "golang"}}},
+		// The emoji is 4 bytes but 1 rune
+		{"emoji before hashtag", "🎉 #party", []Entity{{Type: TypeHashtag, Start: 2, End: 8, Value: "party"}}},
+		{"emoji directly before hashtag", "🎉#party", []Entity{{Type: TypeHashtag, Start: 1, End: 7, Value: "party"}}},
+		// Combining characters are runes of their own
+		{"combining accent", "cafe\u0301 #coffee", []Entity{{Type: TypeHashtag, Start: 6, End: 13, Value: "coffee"}}},
+		{"multibyte hashtag", "Grüße #Straße", []Entity{{Type: TypeHashtag, Start: 6, End: 13, Value: "straße"}}},
+		{"non-latin hashtag", "привет #мир!", []Entity{{Type: TypeHashtag, Start: 7, End: 11, Value: "мир"}}},
+		{"trailing punctuation", "so good #food.", []Entity{{Type: TypeHashtag, Start: 8, End: 13, Value: "food"}}},
+		{"parenthesized", "(#go)", []Entity{{Type: TypeHashtag, Start: 1, End: 4, Value: "go"}}},
+		{"adjacent with comma", "#go,#rust", []Entity{
+			{Type: TypeHashtag, Start: 0, End: 3, Value: "go"},
+			{Type: TypeHashtag, Start: 4, End: 9, Value: "rust"},
+		}},
+		{"adjacent with space", "#go #rust", []Entity{
+			{Type: TypeHashtag, Start: 0, End: 3, Value: "go"},
+			{Type: TypeHashtag, Start: 4, End: 9, Value: "rust"},
+		}},
+		{"hashtag directly after hashtag", "#go#rust", []Entity{{Type: TypeHashtag, Start: 0, End: 3, Value: "go"}}},
+		{"after a word character", "C# and x#y", []Entity{}},
+		{"html entity", "&#39;", []Entity{}},
+		{"only digits", "issue #12", []Entity{}},
+		{"digits and letters", "#2024goals", []Entity{{Type: TypeHashtag, Start: 0, End: 10, Value: "2024goals"}}},
+		{"bare sigil", "# and #!", []Entity{}},
+		{"mention", "hi @alice@example.com!", []Entity{{Type: TypeMention, Start: 3, End: 21, Value: "alice@example.com"}}},
+		{"mention with trailing period", "ask @bob@example.co.uk.", []Entity{{Type: TypeMention, Start: 4, End: 22, Value: "bob@example.co.uk"}}},
+		{"mention after emoji", "👋@alice@example.com", []Entity{{Type: TypeMention, Start: 1, End: 19, Value: "alice@example.com"}}},
+		{"bare email address", "mail alice@example.com", []Entity{}},
+		{"url", "see https://example.com/a?b=c", []Entity{{Type: TypeURL, Start: 4, End: 29, Value: "https://example.com/a?b=c"}}},
+		{"url with trailing punctuation", "(see https://example.com/x).", []Entity{{Type: TypeURL, Start: 5, End: 26, Value: "https://example.com/x"}}},
+		{"url fragment is not a hashtag", "https://example.com/#top", []Entity{{Type: TypeURL, Start: 0, End: 24, Value: "https://example.com/#top"}}},
+		{"url with multibyte path", "🔗 https://example.com/é #tag", []Entity{
+			{Type: TypeURL, Start: 2, End: 23, Value: "https://example.com/é"},
+			{Type: TypeHashtag, Start: 24, End: 28, Value: "tag"},
+		}},
+		{"scheme only", "https:// is not a link", []Entity{}},
+		{"ordered by start", "#a @c@d.e https://f.g", []Entity{
+			{Type: TypeHashtag, Start: 0, End: 2, Value: "a"},
+			{Type: TypeMention, Start: 3, End: 9, Value: "c@d.e"},
+			{Type: TypeURL, Start: 10, End: 21, Value: "https://f.g"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Extract(tt.body)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Extract(%q) = %+v, want %+v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeHashtag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"go", "go"},
+		{"#Go", "go"},
+		{"GOLANG", "golang"},
+		{"#Straße", "straße"},
+		{"##go", "#go"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeHashtag(tt.tag); got != tt.want {
+			t.Errorf("NormalizeHashtag(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
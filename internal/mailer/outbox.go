@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Writes every message to its own .eml file in Dir instead of sending it, for local development and tests.
+type Outbox struct {
+	Dir  string
+	From string
+	mux  sync.Mutex
+	sent int
+}
+
+func NewOutbox(dir, from string) (*Outbox, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &Outbox{Dir: dir, From: from}, nil
+}
+
+func (o *Outbox) Send(msg Message) error {
+	data, err := format(o.From, msg)
+	if err != nil {
+		return err
+	}
+
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	o.sent++
+	name := fmt.Sprintf("%d-%d.eml", time.Now().UnixNano(), o.sent)
+	return os.WriteFile(filepath.Join(o.Dir, name), data, 0600)
+}
@@ -0,0 +1,143 @@
+// Package media turns uploaded images into files that are safe to serve: they are decoded and encoded again, which drops EXIF data such as locations along with anything else that isn't pixels, and a thumbnail is made for each. The EXIF orientation is applied to the pixels first, so that photos still show upright.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+const ContentTypeJPEG = "image/jpeg"
+const ContentTypePNG = "image/png"
+const ContentTypeGIF = "image/gif"
+
+// Images are decoded in full, so huge dimensions could take a lot of memory even for small files. This is enough for common phone cameras, and an image this size takes about 100MB to process.
+const maxPixels = 16_000_000
+
+// Thumbnails fit within a square of this size
+const thumbnailSize = 320
+
+const jpegQuality = 85
+
+var ErrUnsupportedType = errors.New("Only JPEG, PNG and GIF images are supported")
+var ErrTooManyPixels = fmt.Errorf("Images can have at most %d pixels", maxPixels)
+var ErrFileTooLarge = errors.New("Image file is too large")
+
+// An image ready to be stored, along with its thumbnail.
+type Image struct {
+	Data        []byte
+	Thumbnail   []byte
+	ContentType string
+	// The file extension matching the content type, including the dot
+	Ext    string
+	Width  int
+	Height int
+}
+
+// Checks and re-encodes an uploaded image. The type is sniffed from the data rather than trusted from the upload. GIFs are stored as PNGs of their first frame.
+func Process(data []byte) (Image, error) {
+	sniffed := http.DetectContentType(data)
+	if sniffed != ContentTypeJPEG && sniffed != ContentTypePNG && sniffed != ContentTypeGIF {
+		return Image{}, ErrUnsupportedType
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, err
+	}
+	if config.Width*config.Height > maxPixels {
+		return Image{}, ErrTooManyPixels
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, err
+	}
+	if sniffed == ContentTypeJPEG {
+		src = orient(src, jpegOrientation(data))
+	}
+
+	img := Image{ContentType: ContentTypePNG, Ext: ".png", Width: src.Bounds().Dx(), Height: src.Bounds().Dy()}
+	if sniffed == ContentTypeJPEG {
+		img.ContentType, img.Ext = ContentTypeJPEG, ".jpg"
+	}
+	img.Data, err = encode(src, img.ContentType)
+	if err != nil {
+		return Image{}, err
+	}
+	img.Thumbnail, err = encode(thumbnail(src, thumbnailSize), img.ContentType)
+	if err != nil {
+		return Image{}, err
+	}
+	return img, nil
+}
+
+func encode(img image.Image, contentType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if contentType == ContentTypeJPEG {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+// Scales the image down to fit within a size by size square, keeping its aspect ratio. Smaller images are returned as they are.
+//
+// Each pixel of the thumbnail is the average of the pixels it covers in the original, which is cheap and looks fine when shrinking. The original is converted one strip of rows at a time rather than copied in full.
+func thumbnail(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	tw, th := size, h*size/w
+	if h > w {
+		tw, th = w*size/h, size
+	}
+	tw, th = max(tw, 1), max(th, 1)
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	// Holds the rows of the original that one row of the thumbnail covers
+	strip := image.NewRGBA(image.Rect(0, 0, w, h/th+1))
+	for y := range th {
+		y0, y1 := y*h/th, max((y+1)*h/th, y*h/th+1)
+		draw.Draw(strip, image.Rect(0, 0, w, y1-y0), src, image.Pt(b.Min.X, b.Min.Y+y0), draw.Src)
+		for x := range tw {
+			x0, x1 := x*w/tw, max((x+1)*w/tw, x*w/tw+1)
+			var sum [4]int
+			for sy := range y1 - y0 {
+				row := strip.Pix[sy*strip.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for c := range 4 {
+						sum[c] += int(row[sx*4+c])
+					}
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			i := y*dst.Stride + x*4
+			for c := range 4 {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// Reads an upload of at most maxBytes, returning ErrFileTooLarge rather than a truncated image if it is larger.
+func ReadLimited(r io.Reader, maxBytes int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, ErrFileTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,316 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+var red = color.RGBA{255, 0, 0, 255}
+var blue = color.RGBA{0, 0, 255, 255}
+
+// A blue image with a red top left corner, so that its orientation can be told after turning it.
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			img.Set(x, y, blue)
+		}
+	}
+	for y := range max(h/4, 1) {
+		for x := range max(w/4, 1) {
+			img.Set(x, y, red)
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Adds an EXIF segment with the orientation and an unrelated text tag to a JPEG, along with a comment segment, the way cameras do.
+func withExif(data []byte, order binary.AppendByteOrder, orientation uint16, text string) []byte {
+	tiff := []byte("II")
+	if order == binary.BigEndian {
+		tiff = []byte("MM")
+	}
+	tiff = order.AppendUint16(tiff, 42)
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 2)
+	// ImageDescription, an ASCII string stored after the IFD
+	tiff = order.AppendUint16(tiff, 0x010E)
+	tiff = order.AppendUint16(tiff, 2)
+	tiff = order.AppendUint32(tiff, uint32(len(text)+1))
+	tiff = order.AppendUint32(tiff, 8+2+2*12+4)
+	tiff = order.AppendUint16(tiff, orientationTag)
+	tiff = order.AppendUint16(tiff, 3)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, orientation)
+	tiff = order.AppendUint16(tiff, 0)
+	tiff = order.AppendUint32(tiff, 0)
+	tiff = append(tiff, text+"\x00"...)
+
+	segments := []byte{0xFF, 0xD8}
+	exif := append([]byte("Exif\x00\x00"), tiff...)
+	segments = append(segments, 0xFF, 0xE1)
+	segments = binary.BigEndian.AppendUint16(segments, uint16(len(exif)+2))
+	segments = append(segments, exif...)
+	segments = append(segments, 0xFF, 0xFE)
+	segments = binary.BigEndian.AppendUint16(segments, uint16(len(text)+2))
+	segments = append(segments, text...)
+	return append(segments, data[2:]...)
+}
+
+// Whether the pixel is closer to red than to blue, as JPEG doesn't keep colours exactly.
+func isRed(img image.Image, x, y int) bool {
+	r, _, b, _ := img.At(x, y).RGBA()
+	return r > b
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	plain := encodeJPEG(t, testImage(8, 8))
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"no EXIF", plain, 1},
+		{"little endian", withExif(plain, binary.LittleEndian, 6, "x"), 6},
+		{"big endian", withExif(plain, binary.BigEndian, 8, "x"), 8},
+		{"upright", withExif(plain, binary.LittleEndian, 1, "x"), 1},
+		{"out of range", withExif(plain, binary.LittleEndian, 9, "x"), 1},
+		{"truncated", withExif(plain, binary.LittleEndian, 6, "x")[:30], 1},
+		{"not a JPEG", encodePNG(t, testImage(8, 8)), 1},
+		{"empty", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("jpegOrientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// Where the top left pixel of a 3 by 2 image ends up
+	tests := []struct {
+		orientation int
+		w, h        int
+		x, y        int
+	}{
+		{0, 3, 2, 0, 0},
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{4, 3, 2, 0, 1},
+		{5, 2, 3, 0, 0},
+		{6, 2, 3, 1, 0},
+		{7, 2, 3, 1, 2},
+		{8, 2, 3, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.orientation), func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+			src.Set(0, 0, red)
+			got := orient(src, tt.orientation)
+			if b := got.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+				t.Fatalf("Size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.w, tt.h)
+			}
+			for y := range tt.h {
+				for x := range tt.w {
+					if marked := got.At(x, y) == color.Color(red); marked != (x == tt.x && y == tt.y) {
+						t.Errorf("Pixel (%d, %d) = %v", x, y, got.At(x, y))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestThumbnailSize(t *testing.T) {
+	tests := []struct {
+		name         string
+		w, h         int
+		wantW, wantH int
+	}{
+		{"landscape", 640, 480, 320, 240},
+		{"portrait", 480, 640, 240, 320},
+		{"square", 1000, 1000, 320, 320},
+		{"exactly the size", 320, 200, 320, 200},
+		{"small", 100, 50, 100, 50},
+		{"wide strip", 2000, 1, 320, 1},
+		{"tall strip", 1, 2000, 1, 320},
+		{"uneven", 1001, 333, 320, 106},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := thumbnail(testImage(tt.w, tt.h), thumbnailSize)
+			if b := got.Bounds(); b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+				t.Errorf("Thumbnail of %dx%d is %dx%d, want %dx%d", tt.w, tt.h, b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestThumbnailAverages(t *testing.T) {
+	// Every thumbnail pixel covers exactly one red and one blue pixel
+	src := image.NewRGBA(image.Rect(0, 0, 640, 2))
+	for y := range 2 {
+		for x := range 640 {
+			src.Set(x, y, []color.Color{red, blue}[(x+y)%2])
+		}
+	}
+	got := thumbnail(src, thumbnailSize).(*image.RGBA)
+	want := color.RGBA{127, 0, 127, 255}
+	for x := range got.Bounds().Dx() {
+		if c := got.RGBAAt(x, 0); c != want {
+			t.Fatalf("Pixel %d = %v, want %v", x, c, want)
+		}
+	}
+}
+
+// The header of a PNG claiming the given size, which is all DecodeConfig reads.
+func pngHeader(w, h uint32) []byte {
+	ihdr := []byte("IHDR")
+	ihdr = binary.BigEndian.AppendUint32(ihdr, w)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, h)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0)
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, 13)
+	data = append(data, ihdr...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestProcess(t *testing.T) {
+	var gifData bytes.Buffer
+	err := gif.Encode(&gifData, testImage(40, 20), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	landscape := encodeJPEG(t, testImage(800, 400))
+	tests := []struct {
+		name        string
+		data        []byte
+		want        error
+		contentType string
+		w, h        int
+		thumbW      int
+		thumbH      int
+		// Where the red corner of the original ends up, the thumbnail's is on its top row
+		cornerX      int
+		cornerY      int
+		thumbCornerX int
+	}{
+		{"JPEG", landscape, nil, ContentTypeJPEG, 800, 400, 320, 160, 0, 0, 0},
+		{"JPEG turned by EXIF", withExif(landscape, binary.BigEndian, 6, "GPS 55.67N 12.56E"), nil, ContentTypeJPEG, 400, 800, 160, 320, 399, 0, 159},
+		{"PNG", encodePNG(t, testImage(100, 50)), nil, ContentTypePNG, 100, 50, 100, 50, 0, 0, 0},
+		{"GIF stored as PNG", gifData.Bytes(), nil, ContentTypePNG, 40, 20, 40, 20, 0, 0, 0},
+		{"text", []byte("hello, not an image"), ErrUnsupportedType, "", 0, 0, 0, 0, 0, 0, 0},
+		{"too many pixels", pngHeader(5000, 5000), ErrTooManyPixels, "", 0, 0, 0, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Process(tt.data)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Process = %v, want %v", err, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			if img.ContentType != tt.contentType || img.Width != tt.w || img.Height != tt.h {
+				t.Errorf("Processed %s %dx%d, want %s %dx%d", img.ContentType, img.Width, img.Height, tt.contentType, tt.w, tt.h)
+			}
+
+			full, format, err := image.Decode(bytes.NewReader(img.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if "image/"+format != tt.contentType || full.Bounds().Dx() != tt.w || full.Bounds().Dy() != tt.h {
+				t.Errorf("Stored image is a %s of %v", format, full.Bounds())
+			}
+			if !isRed(full, tt.cornerX, tt.cornerY) {
+				t.Errorf("Pixel (%d, %d) is not from the red corner", tt.cornerX, tt.cornerY)
+			}
+			thumb, _, err := image.Decode(bytes.NewReader(img.Thumbnail))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if thumb.Bounds().Dx() != tt.thumbW || thumb.Bounds().Dy() != tt.thumbH {
+				t.Errorf("Thumbnail is %v, want %dx%d", thumb.Bounds(), tt.thumbW, tt.thumbH)
+			}
+			if !isRed(thumb, tt.thumbCornerX, 0) {
+				t.Errorf("Thumbnail pixel (%d, 0) is not from the red corner", tt.thumbCornerX)
+			}
+		})
+	}
+}
+
+func TestProcessStripsMetadata(t *testing.T) {
+	secret := "GPS 55.67N 12.56E"
+	for _, orientation := range []uint16{1, 6} {
+		data := withExif(encodeJPEG(t, testImage(64, 32)), binary.LittleEndian, orientation, secret)
+		img, err := Process(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, out := range map[string][]byte{"image": img.Data, "thumbnail": img.Thumbnail} {
+			if bytes.Contains(out, []byte("Exif")) || bytes.Contains(out, []byte(secret)) {
+				t.Errorf("Orientation %d: metadata left in the %s", orientation, name)
+			}
+			if o := jpegOrientation(out); o != 1 {
+				t.Errorf("Orientation %d: %s has orientation %d, want it upright", orientation, name, o)
+			}
+		}
+	}
+}
+
+func TestReadLimited(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		want error
+	}{
+		{"empty", 0, nil},
+		{"below the limit", 9, nil},
+		{"at the limit", 10, nil},
+		{"over the limit", 11, ErrFileTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ReadLimited(strings.NewReader(strings.Repeat("x", tt.size)), 10)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("ReadLimited = %v, want %v", err, tt.want)
+			}
+			if err == nil && len(data) != tt.size {
+				t.Errorf("Read %d bytes, want %d", len(data), tt.size)
+			}
+		})
+	}
+}
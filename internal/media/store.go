@@ -0,0 +1,77 @@
+package media
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var ErrInvalidName = errors.New("Not the name of a stored file")
+
+// Files are named after the SHA-256 of their contents, thumbnails get ".thumb" added before the extension
+var namePattern = regexp.MustCompile(`^[0-9a-f]{64}(\.thumb)?\.(jpg|png)$`)
+
+// Keeps images in a directory under names derived from their contents. The same image uploaded twice is stored once, and a name always refers to the same contents, so files can be cached forever.
+type Store struct {
+	Dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{Dir: dir}, nil
+}
+
+// Stores the image and its thumbnail, returning their names.
+func (s *Store) Save(img Image) (name, thumbnailName string, err error) {
+	sum := sha256.Sum256(img.Data)
+	base := hex.EncodeToString(sum[:])
+	name, thumbnailName = base+img.Ext, base+".thumb"+img.Ext
+	err = s.write(name, img.Data)
+	if err != nil {
+		return "", "", err
+	}
+	err = s.write(thumbnailName, img.Thumbnail)
+	if err != nil {
+		return "", "", err
+	}
+	return name, thumbnailName, nil
+}
+
+// Files are written under a temporary name and renamed into place, so that a half written file is never served.
+func (s *Store) write(name string, data []byte) error {
+	path := filepath.Join(s.Dir, name)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	tmp, err := os.CreateTemp(s.Dir, "upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Opens a stored file by name. Anything that isn't a name the store hands out is rejected, so that it can come straight from a URL.
+func (s *Store) Open(name string) (*os.File, error) {
+	if !namePattern.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+	return os.Open(filepath.Join(s.Dir, name))
+}
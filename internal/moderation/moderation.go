@@ -0,0 +1,353 @@
+// Package moderation checks chirp bodies against lists of words and phrases, each with an action to take when it appears.
+//
+// Matching works on whole words and ignores case, punctuation and the many ways Unicode has of writing the same letter, so that neither "Kerfuffle!" nor "ｋｅｒｆｕｆｆｌｅ" get through a rule for "kerfuffle".
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Replaces the matched words with asterisks
+const ActionMask = "mask"
+
+// Refuses the chirp altogether
+const ActionReject = "reject"
+
+// Lets the chirp through, but records it for moderators to look at
+const ActionFlag = "flag"
+
+var actions = []string{ActionMask, ActionReject, ActionFlag}
+
+const mask = "****"
+
+var ErrRuleNotFound = errors.New("No rule with that id")
+var ErrRuleExists = errors.New("A rule with that id already exists")
+var ErrInvalidRule = errors.New("Invalid rule")
+
+type Rule struct {
+	Id     string `json:"id"`
+	Action string `json:"action"`
+	// Single words, or phrases of several words that have to appear in order
+	Words []string `json:"words"`
+	Note  string   `json:"note,omitempty"`
+}
+
+func (r Rule) validate() error {
+	if r.Id == "" {
+		return errors.New("Rules need an id")
+	}
+	if !slices.Contains(actions, r.Action) {
+		return fmt.Errorf("Rule %s has unknown action %q, expected one of %s", r.Id, r.Action, strings.Join(actions, ", "))
+	}
+	if len(r.Words) == 0 {
+		return fmt.Errorf("Rule %s has no words", r.Id)
+	}
+	for _, w := range r.Words {
+		if len(tokenize(w)) == 0 {
+			return fmt.Errorf("Rule %s has a word without any letters or digits: %q", r.Id, w)
+		}
+	}
+	return nil
+}
+
+// A rule that matched part of a body.
+type Match struct {
+	RuleId string `json:"rule_id"`
+	Action string `json:"action"`
+	// The matched words as they were written
+	Text string `json:"text"`
+}
+
+type Result struct {
+	// The body with the matches of mask rules replaced
+	Body    string
+	Matches []Match
+}
+
+func (r Result) has(action string) bool {
+	return slices.ContainsFunc(r.Matches, func(m Match) bool {
+		return m.Action == action
+	})
+}
+
+func (r Result) Rejected() bool {
+	return r.has(ActionReject)
+}
+
+func (r Result) Flagged() bool {
+	return r.has(ActionFlag)
+}
+
+// Returns the matches of rules with the action.
+func (r Result) MatchesOf(action string) []Match {
+	matches := []Match{}
+	for _, m := range r.Matches {
+		if m.Action == action {
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// A word or phrase of a rule, as normalized words.
+type phrase struct {
+	words []string
+	rule  *Rule
+}
+
+// Holds the rules from a file, which can be changed and reloaded while in use.
+type Filter struct {
+	path  string
+	rules []Rule
+	// Phrases by their first word
+	phrases map[string][]phrase
+	mux     sync.RWMutex
+}
+
+// The rules that a new rules file starts out with, matching what chirps were always checked for.
+var defaultRules = []Rule{{Id: "profanity", Action: ActionMask, Words: []string{"kerfuffle", "sharbert", "fornax"}}}
+
+// Loads the rules from the file, creating it with the default rules if it doesn't exist.
+func Open(path string) (*Filter, error) {
+	f := &Filter{path: path}
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, f.SetRules(defaultRules)
+	}
+	return f, f.Reload()
+}
+
+// Loads the rules from the file again, to pick up changes made to it by hand. The current rules stay in place if the file is invalid.
+func (f *Filter) Reload() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	var file struct {
+		Rules []Rule `json:"rules"`
+	}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return fmt.Errorf("Invalid rules file %s: %w", f.path, err)
+	}
+	err = validate(file.Rules)
+	if err != nil {
+		return err
+	}
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.use(file.Rules)
+	return nil
+}
+
+func validate(rules []Rule) error {
+	ids := make(map[string]bool)
+	for _, r := range rules {
+		err := r.validate()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+		if ids[r.Id] {
+			return fmt.Errorf("%w: %s", ErrRuleExists, r.Id)
+		}
+		ids[r.Id] = true
+	}
+	return nil
+}
+
+// Must be called with the lock held.
+func (f *Filter) use(rules []Rule) {
+	f.rules = rules
+	f.phrases = make(map[string][]phrase)
+	for i := range f.rules {
+		r := &f.rules[i]
+		for _, w := range r.Words {
+			words := []string{}
+			for _, t := range tokenize(w) {
+				words = append(words, t.normalized)
+			}
+			f.phrases[words[0]] = append(f.phrases[words[0]], phrase{words: words, rule: r})
+		}
+	}
+}
+
+func (f *Filter) Rules() []Rule {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+	return slices.Clone(f.rules)
+}
+
+// Replaces every rule, saving them to the file.
+func (f *Filter) SetRules(rules []Rule) error {
+	return f.update(func([]Rule) ([]Rule, error) {
+		return rules, nil
+	})
+}
+
+func (f *Filter) AddRule(rule Rule) error {
+	return f.update(func(rules []Rule) ([]Rule, error) {
+		if slices.ContainsFunc(rules, func(r Rule) bool { return r.Id == rule.Id }) {
+			return nil, ErrRuleExists
+		}
+		return append(rules, rule), nil
+	})
+}
+
+func (f *Filter) UpdateRule(rule Rule) error {
+	return f.update(func(rules []Rule) ([]Rule, error) {
+		i := slices.IndexFunc(rules, func(r Rule) bool { return r.Id == rule.Id })
+		if i < 0 {
+			return nil, ErrRuleNotFound
+		}
+		rules[i] = rule
+		return rules, nil
+	})
+}
+
+func (f *Filter) DeleteRule(id string) error {
+	return f.update(func(rules []Rule) ([]Rule, error) {
+		i := slices.IndexFunc(rules, func(r Rule) bool { return r.Id == id })
+		if i < 0 {
+			return nil, ErrRuleNotFound
+		}
+		return slices.Delete(rules, i, i+1), nil
+	})
+}
+
+// Applies a change to a copy of the rules, and only saves and uses the result if it is valid.
+func (f *Filter) update(change func([]Rule) ([]Rule, error)) error {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	rules, err := change(slices.Clone(f.rules))
+	if err != nil {
+		return err
+	}
+	err = validate(rules)
+	if err != nil {
+		return err
+	}
+	err = f.save(rules)
+	if err != nil {
+		return err
+	}
+	f.use(rules)
+	return nil
+}
+
+// The file is replaced in one go, so that a crash never leaves it half written. Must be called with the lock held.
+func (f *Filter) save(rules []Rule) error {
+	data, err := json.MarshalIndent(map[string][]Rule{"rules": rules}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// Checks the body against every rule, and masks the words of mask rules.
+func (f *Filter) Check(body string) Result {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	tokens := tokenize(body)
+	result := Result{Matches: []Match{}}
+	// Byte offsets of the text to mask, in order
+	type span struct{ start, end int }
+	masked := []span{}
+	for i, t := range tokens {
+		for _, p := range f.phrases[t.normalized] {
+			if !p.matches(tokens[i:]) {
+				continue
+			}
+			start, end := t.start, tokens[i+len(p.words)-1].end
+			result.Matches = append(result.Matches, Match{RuleId: p.rule.Id, Action: p.rule.Action, Text: body[start:end]})
+			// Overlapping matches are masked as one
+			if p.rule.Action == ActionMask {
+				if n := len(masked); n > 0 && start < masked[n-1].end {
+					masked[n-1].end = max(masked[n-1].end, end)
+				} else {
+					masked = append(masked, span{start, end})
+				}
+			}
+		}
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, s := range masked {
+		b.WriteString(body[last:s.start])
+		b.WriteString(mask)
+		last = s.end
+	}
+	b.WriteString(body[last:])
+	result.Body = b.String()
+	return result
+}
+
+func (p phrase) matches(tokens []token) bool {
+	if len(tokens) < len(p.words) {
+		return false
+	}
+	for i, w := range p.words {
+		if tokens[i].normalized != w {
+			return false
+		}
+	}
+	return true
+}
+
+// A word in a body, along with where it is.
+type token struct {
+	normalized string
+	start, end int
+}
+
+// Words are runs of letters, digits and combining marks. Everything else, such as spaces, punctuation and emoji, separates them.
+func tokenize(s string) []token {
+	tokens := []token{}
+	start := -1
+	for i, r := range s {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			tokens = append(tokens, token{normalized: Normalize(s[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{normalized: Normalize(s[start:]), start: start, end: len(s)})
+	}
+	return tokens
+}
+
+// Brings text into a form where differently written but equivalent words are equal: compatibility characters such as fullwidth letters and ligatures are replaced by their usual forms, and case is folded.
+func Normalize(s string) string {
+	return cases.Fold().String(norm.NFKC.String(s))
+}
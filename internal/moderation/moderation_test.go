@@ -0,0 +1,231 @@
+package moderation
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func openTestFilter(t *testing.T, rules []Rule) *Filter {
+	t.Helper()
+	f, err := Open(filepath.Join(t.TempDir(), "moderation.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rules != nil {
+		err = f.SetRules(rules)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return f
+}
+
+func TestCheckDefaultRules(t *testing.T) {
+	f := openTestFilter(t, nil)
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"clean", "This is a clean chirp", "This is a clean chirp"},
+		{"lowercase", "what a kerfuffle", "what a ****"},
+		{"capitalized with punctuation", "Kerfuffle!", "****!"},
+		{"uppercase", "SHARBERT time", "**** time"},
+		{"fullwidth", "ｋｅｒｆｕｆｆｌｅ", "****"},
+		{"fullwidth in a sentence", "a ｋｅｒｆｕｆｆｌｅ here", "a **** here"},
+		{"several", "kerfuffle, sharbert and fornax.", "****, **** and ****."},
+		{"within a longer word", "kerfuffles and unfornaxed", "kerfuffles and unfornaxed"},
+		{"separated by emoji", "🙈kerfuffle🙉", "🙈****🙉"},
+		{"surrounded by multibyte text", "ça kerfuffle ça", "ça **** ça"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Check(tt.body).Body; got != tt.want {
+				t.Errorf("Check(%q).Body = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPhrases(t *testing.T) {
+	f := openTestFilter(t, []Rule{
+		{Id: "wolf", Action: ActionMask, Words: []string{"big bad", "bad wolf"}},
+		{Id: "nested", Action: ActionMask, Words: []string{"one two three", "two"}},
+	})
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		matches []string
+	}{
+		{"phrase", "a big bad day", "a **** day", []string{"big bad"}},
+		{"punctuation between words", "big, bad!", "****!", []string{"big, bad"}},
+		{"extra spaces between words", "big   bad", "****", []string{"big   bad"}},
+		{"words out of order", "bad big", "bad big", []string{}},
+		{"words apart", "big and bad", "big and bad", []string{}},
+		{"phrase cut off", "so big", "so big", []string{}},
+		{"overlapping phrases are masked as one", "the big bad wolf", "the ****", []string{"big bad", "bad wolf"}},
+		{"phrase containing another", "one two three four", "**** four", []string{"one two three", "two"}},
+		{"separate matches", "big bad and bad wolf", "**** and ****", []string{"big bad", "bad wolf"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := f.Check(tt.body)
+			if result.Body != tt.want {
+				t.Errorf("Check(%q).Body = %q, want %q", tt.body, result.Body, tt.want)
+			}
+			texts := []string{}
+			for _, m := range result.Matches {
+				texts = append(texts, m.Text)
+			}
+			if !reflect.DeepEqual(texts, tt.matches) {
+				t.Errorf("Matched %q, want %q", texts, tt.matches)
+			}
+		})
+	}
+}
+
+func TestCheckActions(t *testing.T) {
+	f := openTestFilter(t, []Rule{
+		{Id: "mask", Action: ActionMask, Words: []string{"kerfuffle"}},
+		{Id: "reject", Action: ActionReject, Words: []string{"buy now"}},
+		{Id: "flag", Action: ActionFlag, Words: []string{"giveaway", "kerfuffle"}},
+	})
+	tests := []struct {
+		name     string
+		body     string
+		want     string
+		rejected bool
+		flagged  bool
+		matches  []Match
+	}{
+		{"clean", "hello", "hello", false, false, []Match{}},
+		{"reject", "Buy now!", "Buy now!", true, false, []Match{{RuleId: "reject", Action: ActionReject, Text: "Buy now"}}},
+		{"flag", "GIVEAWAY", "GIVEAWAY", false, true, []Match{{RuleId: "flag", Action: ActionFlag, Text: "GIVEAWAY"}}},
+		{"mask and flag the same word", "a kerfuffle", "a ****", false, true, []Match{
+			{RuleId: "mask", Action: ActionMask, Text: "kerfuffle"},
+			{RuleId: "flag", Action: ActionFlag, Text: "kerfuffle"},
+		}},
+		{"every action", "giveaway kerfuffle, buy now", "giveaway ****, buy now", true, true, []Match{
+			{RuleId: "flag", Action: ActionFlag, Text: "giveaway"},
+			{RuleId: "mask", Action: ActionMask, Text: "kerfuffle"},
+			{RuleId: "flag", Action: ActionFlag, Text: "kerfuffle"},
+			{RuleId: "reject", Action: ActionReject, Text: "buy now"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := f.Check(tt.body)
+			if result.Body != tt.want {
+				t.Errorf("Body = %q, want %q", result.Body, tt.want)
+			}
+			if result.Rejected() != tt.rejected {
+				t.Errorf("Rejected = %v, want %v", result.Rejected(), tt.rejected)
+			}
+			if result.Flagged() != tt.flagged {
+				t.Errorf("Flagged = %v, want %v", result.Flagged(), tt.flagged)
+			}
+			if !reflect.DeepEqual(result.Matches, tt.matches) {
+				t.Errorf("Matches = %+v, want %+v", result.Matches, tt.matches)
+			}
+		})
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want []token
+	}{
+		{"empty", "", []token{}},
+		{"only punctuation", "!?  ...", []token{}},
+		{"words", "Hello, World!", []token{{"hello", 0, 5}, {"world", 7, 12}}},
+		{"digits", "route 66", []token{{"route", 0, 5}, {"66", 6, 8}}},
+		// Offsets are in bytes; 'é' is 2 bytes and the emoji 4
+		{"multibyte", "é🎉x", []token{{"é", 0, 2}, {"x", 6, 7}}},
+		{"fullwidth", "ＡＢ", []token{{"ab", 0, 6}}},
+		// The combining accent stays part of the word, and composes with the letter
+		{"combining mark", "cafe\u0301!", []token{{"caf\u00e9", 0, 6}}},
+		{"ligature", "ﬁne", []token{{"fine", 0, 5}}},
+		{"case folding", "STRASSE Straße", []token{{"strasse", 0, 7}, {"strasse", 8, 15}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tokenize(tt.s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokenize(%q) = %+v, want %+v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "moderation.json")
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(f.Rules(), defaultRules) {
+		t.Errorf("New filter has rules %+v, want the defaults", f.Rules())
+	}
+
+	spam := Rule{Id: "spam", Action: ActionReject, Words: []string{"buy now"}}
+	if err := f.AddRule(spam); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.AddRule(spam); !errors.Is(err, ErrRuleExists) {
+		t.Errorf("Adding a duplicate rule = %v, want ErrRuleExists", err)
+	}
+	if err := f.AddRule(Rule{Id: "bad", Action: "shout", Words: []string{"x"}}); !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("Adding a rule with an unknown action = %v, want ErrInvalidRule", err)
+	}
+	if err := f.AddRule(Rule{Id: "empty", Action: ActionFlag, Words: []string{"!!"}}); !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("Adding a rule without letters = %v, want ErrInvalidRule", err)
+	}
+	if err := f.UpdateRule(Rule{Id: "missing", Action: ActionFlag, Words: []string{"x"}}); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("Updating a missing rule = %v, want ErrRuleNotFound", err)
+	}
+	if !f.Check("buy now").Rejected() {
+		t.Error("Added rule isn't applied")
+	}
+
+	// Rules survive reopening the file
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reopened.Rules(), f.Rules()) {
+		t.Errorf("Reopened rules = %+v, want %+v", reopened.Rules(), f.Rules())
+	}
+
+	if err := f.DeleteRule("spam"); err != nil {
+		t.Fatal(err)
+	}
+	if f.Check("buy now").Rejected() {
+		t.Error("Deleted rule is still applied")
+	}
+	if err := f.DeleteRule("spam"); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("Deleting a missing rule = %v, want ErrRuleNotFound", err)
+	}
+}
+
+func TestReloadKeepsRulesOnInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "moderation.json")
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte(`{"rules": [{"id": "x", "action": "mask", "words": []}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reload(); !errors.Is(err, ErrInvalidRule) {
+		t.Errorf("Reload = %v, want ErrInvalidRule", err)
+	}
+	if got := f.Check("kerfuffle").Body; got != "****" {
+		t.Errorf("After a failed reload, Check = %q, want the old rules applied", got)
+	}
+}
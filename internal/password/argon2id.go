@@ -0,0 +1,94 @@
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Hashes are stored in the PHC string format, e.g. $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>, so that verification doesn't depend on the currently configured parameters.
+type Argon2id struct {
+	Time uint32
+	// In KiB
+	Memory     uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// The minimum configuration recommended by OWASP
+var DefaultArgon2id = Argon2id{Time: 2, Memory: 19 * 1024, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+const argon2idPrefix = "$argon2id$"
+
+func (a Argon2id) Hash(password string) ([]byte, error) {
+	salt := make([]byte, a.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLength)
+	b64 := base64.RawStdEncoding
+	return []byte(fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, a.Memory, a.Time, a.Threads, b64.EncodeToString(salt), b64.EncodeToString(key))), nil
+}
+
+func (a Argon2id) Recognizes(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte(argon2idPrefix))
+}
+
+func (a Argon2id) Verify(hash []byte, password string) error {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (a Argon2id) Outdated(hash []byte) bool {
+	params, _, _, err := decodeArgon2id(hash)
+	return err != nil || params != a
+}
+
+func decodeArgon2id(hash []byte) (params Argon2id, salt, key []byte, err error) {
+	parts := strings.Split(string(hash), "$")
+	// The leading $ produces an empty first part
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHash
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("Malformed argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("Unsupported argon2id version %d", version)
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("Malformed argon2id parameters: %w", err)
+	}
+
+	b64 := base64.RawStdEncoding
+	salt, err = b64.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("Malformed argon2id salt: %w", err)
+	}
+	key, err = b64.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("Malformed argon2id key: %w", err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
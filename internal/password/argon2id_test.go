@@ -0,0 +1,109 @@
+package password
+
+import (
+	"errors"
+	"testing"
+)
+
+// Small parameters keep the tests fast; correctness doesn't depend on them.
+var testArgon2id = Argon2id{Time: 1, Memory: 64, Threads: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		attempt  string
+		want     error
+	}{
+		{"match", "correct horse battery staple", "correct horse battery staple", nil},
+		{"mismatch", "correct horse battery staple", "correct horse battery stapler", ErrMismatch},
+		{"empty", "", "", nil},
+		{"multibyte", "пароль🔑", "пароль🔑", nil},
+		{"case sensitive", "Password", "password", ErrMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := testArgon2id.Hash(tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !testArgon2id.Recognizes(hash) {
+				t.Errorf("Recognizes(%q) = false", hash)
+			}
+			err = testArgon2id.Verify(hash, tt.attempt)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestArgon2idSalted(t *testing.T) {
+	a, err := testArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := testArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) == string(b) {
+		t.Error("Hashing the same password twice produced identical hashes")
+	}
+}
+
+func TestArgon2idOutdated(t *testing.T) {
+	hash, err := testArgon2id.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		hasher Argon2id
+		want   bool
+	}{
+		{"same parameters", testArgon2id, false},
+		{"more time", Argon2id{Time: 2, Memory: 64, Threads: 1, SaltLength: 16, KeyLength: 32}, true},
+		{"more memory", Argon2id{Time: 1, Memory: 128, Threads: 1, SaltLength: 16, KeyLength: 32}, true},
+		{"more threads", Argon2id{Time: 1, Memory: 64, Threads: 2, SaltLength: 16, KeyLength: 32}, true},
+		{"longer salt", Argon2id{Time: 1, Memory: 64, Threads: 1, SaltLength: 32, KeyLength: 32}, true},
+		{"longer key", Argon2id{Time: 1, Memory: 64, Threads: 1, SaltLength: 16, KeyLength: 64}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.Outdated(hash); got != tt.want {
+				t.Errorf("Outdated = %v, want %v", got, tt.want)
+			}
+			// Verification uses the parameters stored in the hash, not the configured ones
+			if err := tt.hasher.Verify(hash, "password"); err != nil {
+				t.Errorf("Verify = %v", err)
+			}
+		})
+	}
+}
+
+func TestArgon2idMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"bcrypt", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"},
+		{"too few parts", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA"},
+		{"bad version", "$argon2id$v=16$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5"},
+		{"bad parameters", "$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHRzYWx0$a2V5"},
+		{"bad salt", "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5"},
+		{"bad key", "$argon2id$v=19$m=64,t=1,p=1$c2FsdHNhbHRzYWx0$!!!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := testArgon2id.Verify([]byte(tt.hash), "password")
+			if err == nil || errors.Is(err, ErrMismatch) {
+				t.Errorf("Verify = %v, want a malformed hash error", err)
+			}
+			if !testArgon2id.Outdated([]byte(tt.hash)) {
+				t.Error("Outdated = false for a malformed hash")
+			}
+		})
+	}
+}
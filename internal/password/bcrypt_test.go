@@ -0,0 +1,79 @@
+package password
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testBcrypt = Bcrypt{Cost: bcrypt.MinCost}
+
+func TestBcryptRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		attempt  string
+		want     error
+	}{
+		{"match", "correct horse battery staple", "correct horse battery staple", nil},
+		{"mismatch", "correct horse battery staple", "correct horse battery stapler", ErrMismatch},
+		{"multibyte", "пароль🔑", "пароль🔑", nil},
+		{"case sensitive", "Password", "password", ErrMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := testBcrypt.Hash(tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !testBcrypt.Recognizes(hash) {
+				t.Errorf("Recognizes(%q) = false", hash)
+			}
+			err = testBcrypt.Verify(hash, tt.attempt)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBcryptMaxLength(t *testing.T) {
+	long := make([]byte, MaxLength)
+	for i := range long {
+		long[i] = 'a'
+	}
+	_, err := testBcrypt.Hash(string(long))
+	if err != nil {
+		t.Errorf("Hash of a %d byte password = %v", MaxLength, err)
+	}
+	_, err = testBcrypt.Hash(string(long) + "a")
+	if err == nil {
+		t.Errorf("Hash of a %d byte password succeeded; MaxLength is out of date", MaxLength+1)
+	}
+}
+
+func TestBcryptOutdated(t *testing.T) {
+	hash, err := testBcrypt.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		hash []byte
+		cost int
+		want bool
+	}{
+		{"same cost", hash, bcrypt.MinCost, false},
+		{"higher cost", hash, bcrypt.MinCost + 1, true},
+		{"malformed", []byte("$2a$garbage"), bcrypt.MinCost, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (Bcrypt{Cost: tt.cost}).Outdated(tt.hash); got != tt.want {
+				t.Errorf("Outdated = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package password
+
+import (
+	"errors"
+)
+
+var ErrMismatch = errors.New("Password does not match hash")
+var ErrUnknownHash = errors.New("Unrecognized password hash format")
+
+type Hasher interface {
+	Hash(password string) ([]byte, error)
+	// Reports whether the hash was produced by this algorithm, regardless of parameters
+	Recognizes(hash []byte) bool
+	// Returns ErrMismatch if the password doesn't match, or another error if the hash is malformed
+	Verify(hash []byte, password string) error
+	// Reports whether a hash produced by this algorithm was made with parameters other than the configured ones
+	Outdated(hash []byte) bool
+}
+
+// A Scheme hashes new passwords with its preferred hasher, but can still verify hashes made by any supported algorithm so that stored hashes can be migrated over time.
+type Scheme struct {
+	preferred Hasher
+	known     []Hasher
+}
+
+func NewScheme(preferred Hasher) *Scheme {
+	return &Scheme{
+		preferred: preferred,
+		known:     []Hasher{preferred, Bcrypt{Cost: DefaultBcryptCost}, DefaultArgon2id},
+	}
+}
+
+func (s *Scheme) Hash(password string) ([]byte, error) {
+	return s.preferred.Hash(password)
+}
+
+// Verifies the password against the hash, and reports whether the hash should be replaced by a fresh one from the preferred hasher.
+func (s *Scheme) Verify(hash []byte, password string) (needsRehash bool, err error) {
+	for i, h := range s.known {
+		if !h.Recognizes(hash) {
+			continue
+		}
+		err = h.Verify(hash, password)
+		if err != nil {
+			return false, err
+		}
+		// Hashers are only comparable by position, since the preferred hasher may have the same type as one of the defaults
+		return i != 0 || h.Outdated(hash), nil
+	}
+	return false, ErrUnknownHash
+}
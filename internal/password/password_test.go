@@ -0,0 +1,62 @@
+package password
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSchemeVerify(t *testing.T) {
+	mustHash := func(h Hasher) []byte {
+		hash, err := h.Hash("password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	cheaperArgon2id := testArgon2id
+	cheaperArgon2id.Memory = 32
+
+	scheme := NewScheme(testArgon2id)
+	tests := []struct {
+		name        string
+		hash        []byte
+		password    string
+		needsRehash bool
+		err         error
+	}{
+		{"preferred", mustHash(testArgon2id), "password", false, nil},
+		{"preferred mismatch", mustHash(testArgon2id), "wrong", false, ErrMismatch},
+		{"outdated parameters", mustHash(cheaperArgon2id), "password", true, nil},
+		{"other algorithm", mustHash(testBcrypt), "password", true, nil},
+		{"other algorithm mismatch", mustHash(testBcrypt), "wrong", false, ErrMismatch},
+		{"unknown", []byte("$md5$abc"), "password", false, ErrUnknownHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			needsRehash, err := scheme.Verify(tt.hash, tt.password)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Verify error = %v, want %v", err, tt.err)
+			}
+			if needsRehash != tt.needsRehash {
+				t.Errorf("needsRehash = %v, want %v", needsRehash, tt.needsRehash)
+			}
+		})
+	}
+}
+
+func TestSchemeHashUsesPreferred(t *testing.T) {
+	scheme := NewScheme(Bcrypt{Cost: bcrypt.MinCost})
+	hash, err := scheme.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !testBcrypt.Recognizes(hash) {
+		t.Errorf("Hash produced %q, want a bcrypt hash", hash)
+	}
+	needsRehash, err := scheme.Verify(hash, "password")
+	if err != nil || needsRehash {
+		t.Errorf("Verify = %v, %v, want false, nil", needsRehash, err)
+	}
+}
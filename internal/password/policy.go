@@ -0,0 +1,86 @@
+package password
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+const RuleMinLength = "min_length"
+const RuleMaxLength = "max_length"
+const RuleNotEmail = "not_email"
+const RuleNotBreached = "not_breached"
+
+// bcrypt refuses passwords longer than 72 bytes, so that is the most any hasher can be relied on to accept.
+const MaxLength = 72
+
+type Policy struct {
+	MinLength int
+	// Lowercased entries of the breached/common password list
+	breached map[string]struct{}
+}
+
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Lists every rule a password failed, rather than just the first, so users can fix them all in one go.
+type PolicyError struct {
+	Violations []Violation
+}
+
+func (e *PolicyError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Message
+	}
+	return "Password policy violated: " + strings.Join(msgs, "; ")
+}
+
+func NewPolicy(minLength int) *Policy {
+	return &Policy{MinLength: minLength, breached: make(map[string]struct{})}
+}
+
+// Loads a list of breached or common passwords, one per line. Blank lines and lines starting with # are ignored.
+func (p *Policy) LoadBreachedList(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// Returns a *PolicyError if the password fails any rule.
+func (p *Policy) Validate(email, password string) error {
+	violations := []Violation{}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{Rule: RuleMinLength, Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength)})
+	}
+	if len(password) > MaxLength {
+		violations = append(violations, Violation{Rule: RuleMaxLength, Message: fmt.Sprintf("Password must be at most %d bytes long", MaxLength)})
+	}
+	if email != "" && strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(email)) {
+		violations = append(violations, Violation{Rule: RuleNotEmail, Message: "Password must not be the same as the email address"})
+	}
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		violations = append(violations, Violation{Rule: RuleNotBreached, Message: "Password appears in a list of breached or common passwords"})
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
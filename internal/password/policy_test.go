@@ -0,0 +1,77 @@
+package password
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyValidate(t *testing.T) {
+	dir := t.TempDir()
+	list := filepath.Join(dir, "breached.txt")
+	err := os.WriteFile(list, []byte("# common passwords\n\nhunter2hunter2\n  Password123  \n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := NewPolicy(8)
+	err = policy.LoadBreachedList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		rules    []string
+	}{
+		{"valid", "user@example.com", "correct horse", nil},
+		{"exactly min length", "", "abcdefgh", nil},
+		{"too short", "", "abcdefg", []string{RuleMinLength}},
+		// Length is counted in characters, not bytes
+		{"multibyte too short", "", "ååååååå", []string{RuleMinLength}},
+		{"multibyte long enough", "", "åååååååå", nil},
+		{"exactly max length", "", strings.Repeat("a", MaxLength), nil},
+		{"too long", "", strings.Repeat("a", MaxLength+1), []string{RuleMaxLength}},
+		// 36 two-byte characters is 72 bytes; one more is over bcrypt's limit
+		{"multibyte too long", "", strings.Repeat("å", MaxLength/2+1), []string{RuleMaxLength}},
+		{"same as email", "user@example.com", "user@example.com", []string{RuleNotEmail}},
+		{"same as email ignoring case and space", "User@Example.com", " user@example.COM ", []string{RuleNotEmail}},
+		{"no email given", "", "user@example.com", nil},
+		{"breached", "", "hunter2hunter2", []string{RuleNotBreached}},
+		{"breached ignoring case", "", "PASSWORD123", []string{RuleNotBreached}},
+		{"comment lines are not passwords", "", "# common passwords", nil},
+		{"short and same as email", "a@b.co", "a@b.co", []string{RuleMinLength, RuleNotEmail}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.email, tt.password)
+			if len(tt.rules) == 0 {
+				if err != nil {
+					t.Errorf("Validate = %v, want nil", err)
+				}
+				return
+			}
+			var perr *PolicyError
+			if !errors.As(err, &perr) {
+				t.Fatalf("Validate = %v, want a *PolicyError", err)
+			}
+			got := make([]string, len(perr.Violations))
+			for i, v := range perr.Violations {
+				got[i] = v.Rule
+			}
+			if strings.Join(got, ",") != strings.Join(tt.rules, ",") {
+				t.Errorf("Violated rules = %v, want %v", got, tt.rules)
+			}
+		})
+	}
+}
+
+func TestLoadBreachedListMissingFile(t *testing.T) {
+	err := NewPolicy(8).LoadBreachedList(filepath.Join(t.TempDir(), "missing.txt"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadBreachedList = %v, want os.ErrNotExist", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Package totp implements time-based one-time passwords as described in RFC 6238, with the parameters that authenticator apps support universally: HMAC-SHA1, 6 digits and a 30 second period.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const Digits = 6
+const Period = 30 * time.Second
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Returns a random 160 bit secret, base32 encoded as expected by authenticator apps.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// Returns the otpauth:// URI that authenticator apps import, usually by scanning it as a QR code.
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period.Seconds())))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+func Counter(t time.Time) uint64 {
+	return uint64(t.Unix()) / uint64(Period.Seconds())
+}
+
+// Returns the code for the time step containing t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, Counter(t)), nil
+}
+
+// Checks the code against the time steps within skew steps of t, to allow for clock drift and slow typing. Returns the counter of the matching step, which callers should remember so that a code can't be used twice.
+func Validate(secret, c string, t time.Time, skew int) (uint64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+	c = strings.ReplaceAll(c, " ", "")
+	now := Counter(t)
+	for i := -skew; i <= skew; i++ {
+		counter := now + uint64(i)
+		if subtle.ConstantTimeCompare([]byte(code(key, counter)), []byte(c)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	return encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+}
+
+// HOTP as defined in RFC 4226, section 5.3
+func code(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for range Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
@@ -0,0 +1,172 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// The SHA1 seed from RFC 6238, appendix B, base32 encoded.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// RFC 6238 appendix B lists 8 digit codes; with 6 digits the code is the last 6 of those.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "94287082"},
+	{1111111109, "07081804"},
+	{1111111111, "14050471"},
+	{1234567890, "89005924"},
+	{2000000000, "69279037"},
+	{20000000000, "65353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, tt := range rfcVectors {
+		t.Run(tt.code, func(t *testing.T) {
+			got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.code[len(tt.code)-Digits:]
+			if got != want {
+				t.Errorf("Code at %d = %s, want %s", tt.unix, got, want)
+			}
+		})
+	}
+}
+
+func TestValidateRFC6238(t *testing.T) {
+	for _, tt := range rfcVectors {
+		t.Run(tt.code, func(t *testing.T) {
+			now := time.Unix(tt.unix, 0)
+			counter, ok := Validate(rfcSecret, tt.code[len(tt.code)-Digits:], now, 0)
+			if !ok {
+				t.Fatalf("Validate at %d rejected its own code", tt.unix)
+			}
+			if counter != Counter(now) {
+				t.Errorf("Validate counter = %d, want %d", counter, Counter(now))
+			}
+		})
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := func(n int) string {
+		c, err := Code(rfcSecret, now.Add(time.Duration(n)*Period))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		offset int
+		skew   int
+		ok     bool
+	}{
+		{"current step, no skew", 0, 0, true},
+		{"previous step, no skew", -1, 0, false},
+		{"next step, no skew", 1, 0, false},
+		{"previous step within skew", -1, 1, true},
+		{"next step within skew", 1, 1, true},
+		{"two steps behind, skew 1", -2, 1, false},
+		{"two steps ahead, skew 1", 2, 1, false},
+		{"two steps behind, skew 2", -2, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := Validate(rfcSecret, step(tt.offset), now, tt.skew)
+			if ok != tt.ok {
+				t.Fatalf("Validate = %v, want %v", ok, tt.ok)
+			}
+			// The counter identifies the step the code belongs to, not the current one
+			want := Counter(now) + uint64(tt.offset)
+			if ok && counter != want {
+				t.Errorf("Validate counter = %d, want %d", counter, want)
+			}
+		})
+	}
+}
+
+func TestValidateStepBoundary(t *testing.T) {
+	// 1111111109 and 1111111111 straddle a step boundary, and have different codes
+	before := time.Unix(1111111109, 0)
+	after := time.Unix(1111111111, 0)
+	c, err := Code(rfcSecret, before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(rfcSecret, c, after, 0); ok {
+		t.Error("Code from the previous step accepted without skew")
+	}
+	if _, ok := Validate(rfcSecret, c, after, 1); !ok {
+		t.Error("Code from the previous step rejected with skew 1")
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+	}{
+		{"spaces are ignored", rfcSecret, "005 924", true},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "005924", true},
+		{"padded secret", rfcSecret + "====", "005924", true},
+		{"wrong code", rfcSecret, "005925", false},
+		{"too short", rfcSecret, "05924", false},
+		{"empty code", rfcSecret, "", false},
+		{"invalid secret", "not base32!", "005924", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := Validate(tt.secret, tt.code, now, 1); ok != tt.ok {
+				t.Errorf("Validate = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("GenerateSecret returned the same secret twice")
+	}
+	key, err := decodeSecret(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 20 {
+		t.Errorf("Secret is %d bytes, want 20", len(key))
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Chirpy", "user@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Chirpy:user@example.com" {
+		t.Errorf("URI = %s", u)
+	}
+	q := u.Query()
+	want := map[string]string{"secret": rfcSecret, "issuer": "Chirpy", "algorithm": "SHA1", "digits": "6", "period": "30"}
+	for k, v := range want {
+		if q.Get(k) != v {
+			t.Errorf("%s = %q, want %q", k, q.Get(k), v)
+		}
+	}
+}
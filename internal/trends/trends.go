@@ -0,0 +1,140 @@
+// Package trends keeps track of which hashtags are used the most right now.
+//
+// Every tag has one score per window, which goes up by one for each use and decays exponentially with the window as its time constant. A score is thus roughly the number of uses within the last window, with older uses fading out gradually rather than dropping off at once, and only needs constant space no matter how much the tag is used.
+package trends
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUnknownWindow = errors.New("No such trend window")
+
+// Scores below this are dropped, as they no longer say anything about the tag
+const minVolume = 0.01
+
+// Uses older than this many windows have decayed below minVolume and are not worth replaying
+const horizonWindows = 5
+
+// A hashtag being used at some point in time.
+type Use struct {
+	Tag string
+	At  time.Time
+}
+
+type Trend struct {
+	Tag    string  `json:"tag"`
+	Volume float64 `json:"volume"`
+}
+
+type score struct {
+	value float64
+	at    time.Time
+}
+
+// Moves the score to the given time, decaying it over the time that passed.
+func (s score) decayedTo(t time.Time, window time.Duration) float64 {
+	return s.value * math.Exp(-float64(t.Sub(s.at))/float64(window))
+}
+
+type Tracker struct {
+	windows []time.Duration
+	// One score per window for every tag
+	tags map[string][]score
+	mux  sync.Mutex
+}
+
+func New(windows []time.Duration) *Tracker {
+	return &Tracker{windows: windows, tags: make(map[string][]score)}
+}
+
+func (t *Tracker) Windows() []time.Duration {
+	return t.windows
+}
+
+// How far back uses still count for anything. Rebuilding only needs the uses within it.
+func (t *Tracker) Horizon() time.Duration {
+	return horizonWindows * slices.Max(t.windows)
+}
+
+func (t *Tracker) Record(tag string, at time.Time) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.record(tag, at)
+}
+
+// Must be called with the lock held.
+func (t *Tracker) record(tag string, at time.Time) {
+	scores := t.tags[tag]
+	if scores == nil {
+		scores = make([]score, len(t.windows))
+		t.tags[tag] = scores
+	}
+	for i, w := range t.windows {
+		s := &scores[i]
+		if at.After(s.at) {
+			s.value = s.decayedTo(at, w) + 1
+			s.at = at
+		} else {
+			// Uses can arrive out of order, in which case they are decayed to the time of the score instead
+			s.value += score{value: 1, at: at}.decayedTo(s.at, w)
+		}
+	}
+}
+
+// Replaces all scores with those resulting from the uses, for example after starting up or after uses were removed.
+func (t *Tracker) Rebuild(uses []Use) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	t.tags = make(map[string][]score)
+	for _, u := range uses {
+		t.record(u.Tag, u.At)
+	}
+}
+
+// Returns the n tags with the highest volume in the window, highest first.
+func (t *Tracker) Top(window time.Duration, n int, now time.Time) ([]Trend, error) {
+	i := slices.Index(t.windows, window)
+	if i < 0 {
+		return nil, ErrUnknownWindow
+	}
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	trends := []Trend{}
+	for tag, scores := range t.tags {
+		volume := scores[i].decayedTo(now, window)
+		if volume >= minVolume {
+			trends = append(trends, Trend{Tag: tag, Volume: math.Round(volume*100) / 100})
+		} else if t.faded(scores, now) {
+			delete(t.tags, tag)
+		}
+	}
+	slices.SortFunc(trends, func(a, b Trend) int {
+		if a.Volume != b.Volume {
+			if a.Volume > b.Volume {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Tag, b.Tag)
+	})
+	if len(trends) > n {
+		trends = trends[:n]
+	}
+	return trends, nil
+}
+
+// Whether the tag has faded out of every window. Must be called with the lock held.
+func (t *Tracker) faded(scores []score, now time.Time) bool {
+	for i, w := range t.windows {
+		if scores[i].decayedTo(now, w) >= minVolume {
+			return false
+		}
+	}
+	return true
+}
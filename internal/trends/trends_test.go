@@ -0,0 +1,157 @@
+package trends
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestDecay(t *testing.T) {
+	tests := []struct {
+		name  string
+		uses  []time.Duration
+		after time.Duration
+		want  float64
+	}{
+		{"fresh use", []time.Duration{0}, 0, 1},
+		{"one window later", []time.Duration{0}, time.Hour, 0.37},
+		{"two windows later", []time.Duration{0}, 2 * time.Hour, 0.14},
+		{"several uses at once", []time.Duration{0, 0, 0}, 0, 3},
+		{"uses spread out", []time.Duration{0, time.Hour}, time.Hour, 1.37},
+		{"out of order", []time.Duration{time.Hour, 0}, time.Hour, 1.37},
+		{"half a window later", []time.Duration{0}, 30 * time.Minute, 0.61},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := New([]time.Duration{time.Hour})
+			for _, at := range tt.uses {
+				tracker.Record("go", start.Add(at))
+			}
+			top, err := tracker.Top(time.Hour, 10, start.Add(tt.after))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(top) != 1 || top[0].Volume != tt.want {
+				t.Errorf("Top = %v, want volume %v", top, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindows(t *testing.T) {
+	tracker := New([]time.Duration{time.Hour, 24 * time.Hour})
+	tracker.Record("go", start)
+	now := start.Add(6 * time.Hour)
+
+	tests := []struct {
+		window time.Duration
+		want   float64
+	}{
+		// exp(-6) has dropped below the cut-off, while exp(-6/24) is still most of the use
+		{time.Hour, 0},
+		{24 * time.Hour, 0.78},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window.String(), func(t *testing.T) {
+			top, err := tracker.Top(tt.window, 10, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got float64
+			if len(top) > 0 {
+				got = top[0].Volume
+			}
+			if got != tt.want {
+				t.Errorf("Volume = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := tracker.Top(time.Minute, 10, now); !errors.Is(err, ErrUnknownWindow) {
+		t.Errorf("Top with an unconfigured window = %v, want ErrUnknownWindow", err)
+	}
+}
+
+func TestTop(t *testing.T) {
+	tracker := New([]time.Duration{time.Hour})
+	uses := map[string]int{"go": 3, "rust": 2, "zig": 2, "c": 1}
+	for tag, n := range uses {
+		for range n {
+			tracker.Record(tag, start)
+		}
+	}
+
+	tests := []struct {
+		name string
+		n    int
+		want []string
+	}{
+		{"all", 10, []string{"go", "rust", "zig", "c"}},
+		{"ties broken by tag", 3, []string{"go", "rust", "zig"}},
+		{"limited", 1, []string{"go"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			top, err := tracker.Top(time.Hour, tt.n, start)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, trend := range top {
+				got = append(got, trend.Tag)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Top(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFadedTagsDropped(t *testing.T) {
+	tracker := New([]time.Duration{time.Hour, 24 * time.Hour})
+	tracker.Record("go", start)
+
+	// Faded from the short window only, so the score is kept for the long one
+	tracker.Top(time.Hour, 10, start.Add(6*time.Hour))
+	if _, ok := tracker.tags["go"]; !ok {
+		t.Fatal("Tag dropped while it still counts in the long window")
+	}
+
+	tracker.Top(time.Hour, 10, start.Add(tracker.Horizon()))
+	if _, ok := tracker.tags["go"]; ok {
+		t.Error("Tag kept after fading out of every window")
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	live := New([]time.Duration{time.Hour})
+	rebuilt := New([]time.Duration{time.Hour})
+	uses := []Use{{"go", start}, {"go", start.Add(10 * time.Minute)}, {"rust", start.Add(20 * time.Minute)}}
+	for _, u := range uses {
+		live.Record(u.Tag, u.At)
+	}
+	rebuilt.Record("stale", start)
+	rebuilt.Rebuild(uses)
+
+	now := start.Add(30 * time.Minute)
+	want, _ := live.Top(time.Hour, 10, now)
+	got, _ := rebuilt.Top(time.Hour, 10, now)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Rebuilt trends = %v, want %v", got, want)
+	}
+}
+
+func TestHorizon(t *testing.T) {
+	tracker := New([]time.Duration{time.Hour, 24 * time.Hour})
+	if got := tracker.Horizon(); got != 5*24*time.Hour {
+		t.Errorf("Horizon = %v, want %v", got, 5*24*time.Hour)
+	}
+	// A use from the horizon back has decayed below the cut-off in every window
+	if v := math.Exp(-horizonWindows); v >= minVolume {
+		t.Errorf("exp(-%d) = %v is not below minVolume", horizonWindows, v)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Lets other services on the network check Chirpy tokens, following RFC 7662. Callers authenticate with client credentials from INTROSPECTION_CLIENTS.
+func handlePostIntrospect(auth *authenticator, clients map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+
+		clientId, clientSecret, ok := r.BasicAuth()
+		if !ok {
+			clientId, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+		}
+		if !validClientCredentials(clients, clientId, clientSecret) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+			respondWithError(w, 401, "invalid_client", nil)
+			return
+		}
+
+		tokenString := r.PostFormValue("token")
+		if tokenString == "" {
+			respondWithError(w, 400, "invalid_request", nil)
+			return
+		}
+		log.Println(rid, "handlePostIntrospect for client", clientId)
+
+		type response struct {
+			Active    bool   `json:"active"`
+			Scope     string `json:"scope,omitempty"`
+			ClientId  string `json:"client_id,omitempty"`
+			TokenType string `json:"token_type,omitempty"`
+			Subject   string `json:"sub,omitempty"`
+			Issuer    string `json:"iss,omitempty"`
+			TokenId   string `json:"jti,omitempty"`
+			IssuedAt  int64  `json:"iat,omitempty"`
+			ExpiresAt int64  `json:"exp,omitempty"`
+		}
+
+		// Introspecting a token is not a use of it
+		info, err := auth.inspect(tokenString, false)
+		if err != nil {
+			// The reason a token is inactive is deliberately not revealed
+			log.Println(rid, "Introspected inactive token:", err)
+			respondWithJSON(w, 200, response{Active: false})
+			return
+		}
+
+		scopes := info.Scopes
+		if scopes == nil {
+			scopes = append(slices.Clone(grantableScopes), scopeAccount)
+		}
+		resp := response{
+			Active:    true,
+			Scope:     strings.Join(scopes, " "),
+			ClientId:  info.ClientId,
+			TokenType: info.TokenType,
+			Subject:   fmt.Sprint(info.UserId),
+			Issuer:    info.Issuer,
+			TokenId:   info.TokenId,
+			IssuedAt:  info.IssuedAt.Unix(),
+		}
+		if !info.ExpiresAt.IsZero() {
+			resp.ExpiresAt = info.ExpiresAt.Unix()
+		}
+		respondWithJSON(w, 200, resp)
+	})
+}
+
+func validClientCredentials(clients map[string]string, clientId, clientSecret string) bool {
+	secret, ok := clients[clientId]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(clientSecret), []byte(secret)) == 1
+}
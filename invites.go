@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+func handlePostInvites(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		type parameters struct {
+			Note       string `json:"note"`
+			MaxUses    int    `json:"max_uses"`
+			Expiration int    `json:"expires_in_seconds"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		log.Println(rid, "handlePostInvites", params.Note, params.MaxUses)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		// Invites are single use unless stated otherwise
+		if params.MaxUses == 0 {
+			params.MaxUses = 1
+		}
+		if params.MaxUses < 0 {
+			respondWithError(w, 400, "max_uses must be positive", nil)
+			return
+		}
+		if params.Expiration < 0 {
+			respondWithError(w, 400, "Expiration must not be negative", nil)
+			return
+		}
+		// No expiration means the invite is valid until used up or deleted
+		var expiresAt *time.Time
+		if params.Expiration > 0 {
+			t := time.Now().Add(time.Duration(params.Expiration) * time.Second)
+			expiresAt = &t
+		}
+
+		code, err := newRandomToken()
+		if err != nil {
+			respondWithError(w, 500, "Error generating invite code", err)
+			return
+		}
+		inv, err := db.CreateInvite(strings.TrimSpace(params.Note), hashToken(code), params.MaxUses, expiresAt)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+
+		// This is the only time the code itself is ever shown
+		type response struct {
+			database.Invite
+			Code string `json:"code"`
+		}
+		respondWithJSON(w, 201, response{Invite: inv, Code: code})
+	})
+}
+
+func handleGetInvites(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		invites, err := db.GetInvites()
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, invites)
+	})
+}
+
+func handleDeleteInvite(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given invite ID is not a number", err)
+			return
+		}
+		log.Println(rid, "handleDeleteInvite", id)
+
+		err = db.DeleteInvite(id)
+		if errors.Is(err, database.ErrInviteNotFound) {
+			respondWithError(w, 404, "Invite not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+func handlePutLike(db *database.DB, auth *authenticator) http.Handler {
+	return handleLike(db, auth, true)
+}
+
+func handleDeleteLike(db *database.DB, auth *authenticator) http.Handler {
+	return handleLike(db, auth, false)
+}
+
+// Liking and unliking are both idempotent, so clients can simply send the state they want.
+func handleLike(db *database.DB, auth *authenticator, like bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeChirpsWrite)
+		if !ok {
+			return
+		}
+		chirpId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given chirp ID is not a number", err)
+			return
+		}
+		log.Println(rid, "handleLike", chirpId, like, "for user", info.UserId)
+
+		setLike := db.UnlikeChirp
+		if like {
+			setLike = db.LikeChirp
+		}
+		chirp, err := setLike(chirpId, info.UserId)
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, 404, "Couldn't retrieve chirp", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		chirp.LikedByMe = &like
+		respondWithJSON(w, 200, chirp)
+	})
+}
+
+func handleGetUserLikes(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := auth.optional(r, scopeChirpsRead)
+		userId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given user ID is not a number", err)
+			return
+		}
+		chirps, err := db.GetLikedChirps(userId)
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, 404, "User not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		err = markLikedByMe(db, info, chirps)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, chirps)
+	})
+}
+
+// Fills in liked_by_me for authenticated requests. Anonymous requests get chirps without it.
+func markLikedByMe(db *database.DB, info authInfo, chirps []Chirp) error {
+	if info.UserId == 0 {
+		return nil
+	}
+	liked, err := db.GetLikedChirpIds(info.UserId)
+	if err != nil {
+		return err
+	}
+	for i := range chirps {
+		setLikedByMe(&chirps[i], liked)
+	}
+	return nil
+}
+
+func markChirpLikedByMe(db *database.DB, info authInfo, chirp *Chirp) error {
+	chirps := []Chirp{*chirp}
+	err := markLikedByMe(db, info, chirps)
+	*chirp = chirps[0]
+	return err
+}
+
+func markThreadLikedByMe(db *database.DB, info authInfo, thread *database.ThreadNode) error {
+	if info.UserId == 0 {
+		return nil
+	}
+	liked, err := db.GetLikedChirpIds(info.UserId)
+	if err != nil {
+		return err
+	}
+	var mark func(node *database.ThreadNode)
+	mark = func(node *database.ThreadNode) {
+		setLikedByMe(&node.Chirp, liked)
+		for i := range node.Replies {
+			mark(&node.Replies[i])
+		}
+	}
+	mark(thread)
+	return nil
+}
+
+func setLikedByMe(chirp *Chirp, liked map[int]bool) {
+	// Tombstones don't reveal anything about the chirp they stand in for
+	if chirp.Tombstone {
+		return
+	}
+	l := liked[chirp.Id]
+	chirp.LikedByMe = &l
+	if chirp.Embedded != nil {
+		setLikedByMe(chirp.Embedded, liked)
+	}
+}
@@ -6,9 +6,15 @@ import (
 	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/mailer"
+	"github.com/madsbv/go-server-exercise/internal/media"
+	"github.com/madsbv/go-server-exercise/internal/moderation"
+	"github.com/madsbv/go-server-exercise/internal/password"
+	"github.com/madsbv/go-server-exercise/internal/trends"
 )
 
 func main() {
@@ -20,7 +26,26 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
-	apiCfg := apiConfig{jwtSecret: []byte(os.Getenv("JWT_SECRET")), polkaSecret: os.Getenv("POLKA_SECRET")}
+	apiCfg := apiConfig{
+		jwtSecret:      []byte(os.Getenv("JWT_SECRET")),
+		polkaSecret:    os.Getenv("POLKA_SECRET"),
+		adminSecret:    os.Getenv("ADMIN_SECRET"),
+		passwordPolicy: passwordPolicyFromEnv(),
+		mailer:         mailerFromEnv(),
+		// Users can always log in, but only post chirps once they have verified their email address
+		requireVerifiedEmail: getenvBool("REQUIRE_VERIFIED_EMAIL", false),
+		introspectionClients: introspectionClientsFromEnv(),
+		// Only turn this off when serving plain HTTP during development, browsers won't send secure cookies otherwise
+		cookieSecure:     getenvBool("COOKIE_SECURE", true),
+		registrationMode: registrationModeFromEnv(),
+		// Successful signups allowed per IP and hour, 0 disables the limit
+		signupRateLimit: getenvInt("SIGNUP_RATE_LIMIT", 5),
+		// How long after posting authors may edit a chirp, 0 disables editing
+		chirpEditWindow: getenvDuration("CHIRP_EDIT_WINDOW", 15*time.Minute),
+		mediaMaxBytes:   int64(getenvInt("MEDIA_MAX_BYTES", 5<<20)),
+		// Uploads allowed per user and hour, 0 disables the limit
+		mediaUploadRateLimit: getenvInt("MEDIA_UPLOAD_RATE_LIMIT", 30),
+	}
 
 	port := "8080"
 	dbPath := "database.json"
@@ -30,11 +55,35 @@ func main() {
 		_ = os.Remove(dbPath)
 	}
 
-	db, err := database.NewDB(dbPath)
+	db, err := database.NewDB(dbPath, passwordHasherFromEnv())
 	if err != nil {
 		log.Fatal("Failed to create database connection: ", err)
 	}
 
+	apiCfg.tokenDenylist, err = newTokenDenylist(db)
+	if err != nil {
+		log.Fatal("Failed to load token denylist: ", err)
+	}
+	go apiCfg.tokenDenylist.runCleanup(10 * time.Minute)
+
+	apiCfg.trends = trends.New(trendWindowsFromEnv())
+	err = rebuildTrends(db, apiCfg.trends)
+	if err != nil {
+		log.Fatal("Failed to load hashtag trends: ", err)
+	}
+	go runTrendRebuilds(db, apiCfg.trends, 10*time.Minute)
+
+	// Created with the long standing list of masked words if it doesn't exist
+	apiCfg.moderation, err = moderation.Open(getenvString("MODERATION_RULES_FILE", "moderation.json"))
+	if err != nil {
+		log.Fatal("Failed to load moderation rules: ", err)
+	}
+
+	apiCfg.mediaStore, err = media.NewStore(getenvString("MEDIA_DIR", "media"))
+	if err != nil {
+		log.Fatal("Failed to create media directory: ", err)
+	}
+
 	logger := log.New(os.Stdout, "http: ", log.LstdFlags)
 	logger.Printf("Serving files from %s on port: %s\n", filepathRoot, port)
 
@@ -53,6 +102,21 @@ type apiConfig struct {
 		count int
 		mux   sync.RWMutex
 	}
-	jwtSecret   []byte
-	polkaSecret string
+	jwtSecret            []byte
+	polkaSecret          string
+	adminSecret          string
+	passwordPolicy       *password.Policy
+	mailer               mailer.Mailer
+	requireVerifiedEmail bool
+	tokenDenylist        *tokenDenylist
+	introspectionClients map[string]string
+	cookieSecure         bool
+	registrationMode     string
+	signupRateLimit      int
+	chirpEditWindow      time.Duration
+	trends               *trends.Tracker
+	mediaStore           *media.Store
+	moderation           *moderation.Filter
+	mediaMaxBytes        int64
+	mediaUploadRateLimit int
 }
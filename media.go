@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/media"
+)
+
+const maxChirpMedia = 4
+
+// Room for the multipart headers around the file itself
+const multipartOverhead = 1 << 20
+
+// Processing a large image takes around 100MB, so only this many are processed at once and further uploads wait their turn
+const maxConcurrentImageProcessing = 2
+
+// Expects the image in the "file" field of a multipart form. Only the re-encoded image is kept, never the upload itself. Each upload takes one of the processing slots while it is decoded and encoded.
+func handlePostMedia(db *database.DB, auth *authenticator, store *media.Store, maxBytes int64, limiter *rateLimiter, slots chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeChirpsWrite)
+		if !ok {
+			return
+		}
+		log.Println(rid, "handlePostMedia for user", info.UserId)
+		key := fmt.Sprint(info.UserId)
+		if !limiter.allow(key) {
+			respondWithTooManyRequests(w, limiter.retryAfter(key), "Too many uploads, try again later")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes+multipartOverhead)
+		file, _, err := r.FormFile("file")
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, 413, media.ErrFileTooLarge.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 400, "Expected an image in the file field of a multipart form", err)
+			return
+		}
+		defer file.Close()
+		data, err := media.ReadLimited(file, maxBytes)
+		if errors.Is(err, media.ErrFileTooLarge) {
+			respondWithError(w, 413, err.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 400, "Failed to read upload", err)
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+		img, err := media.Process(data)
+		<-slots
+		if errors.Is(err, media.ErrUnsupportedType) {
+			respondWithError(w, 415, err.Error(), err)
+			return
+		}
+		if errors.Is(err, media.ErrTooManyPixels) {
+			respondWithError(w, 413, err.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 400, "Couldn't decode image", err)
+			return
+		}
+		name, thumbnailName, err := store.Save(img)
+		if err != nil {
+			respondWithError(w, 500, "Error storing image", err)
+			return
+		}
+
+		id, err := newRandomId()
+		if err != nil {
+			respondWithError(w, 500, "Error generating media ID", err)
+			return
+		}
+		m, err := db.CreateMedia(database.Media{
+			Id:           id,
+			OwnerId:      info.UserId,
+			ContentType:  img.ContentType,
+			Width:        img.Width,
+			Height:       img.Height,
+			URL:          "/media/" + name,
+			ThumbnailURL: "/media/" + thumbnailName,
+		})
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 201, m)
+	})
+}
+
+// Stored files are named after their contents and never change, so clients and proxies may cache them for good.
+func handleGetMedia(store *media.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		f, err := store.Open(name)
+		if errors.Is(err, media.ErrInvalidName) || errors.Is(err, os.ErrNotExist) {
+			respondWithError(w, 404, "Media not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Error opening media", err)
+			return
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			respondWithError(w, 500, "Error opening media", err)
+			return
+		}
+
+		contentType := media.ContentTypePNG
+		if filepath.Ext(name) == ".jpg" {
+			contentType = media.ContentTypeJPEG
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("ETag", `"`+strings.TrimSuffix(name, filepath.Ext(name))+`"`)
+		http.ServeContent(w, r, name, stat.ModTime(), f)
+	})
+}
+
+// Chirps can carry a few images, each attached at most once.
+func validateChirpMedia(ids []string) error {
+	if len(ids) > maxChirpMedia {
+		return errors.New("Chirps can have at most 4 media attachments")
+	}
+	for i, id := range ids {
+		for _, other := range ids[:i] {
+			if id == other {
+				return errors.New("Media can only be attached once")
+			}
+		}
+	}
+	return nil
+}
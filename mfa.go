@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/totp"
+)
+
+const expirationMFASeconds = 60 * 5 // 5 minutes
+const mfaIssuer = "chirpy-mfa"
+const totpIssuerName = "Chirpy"
+
+// Accept codes from one time step either side of the current one
+const totpSkew = 1
+const recoveryCodeCount = 10
+
+var errInvalidSecondFactor = errors.New("Invalid two-factor code")
+
+func handlePostTOTPEnroll(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		id := info.UserId
+		log.Println(rid, "handlePostTOTPEnroll for user", id)
+
+		user, err := db.GetUser(id)
+		if err != nil {
+			respondWithError(w, 404, "User not found", err)
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			respondWithError(w, 500, "Error generating secret", err)
+			return
+		}
+		err = db.BeginTOTPEnrollment(id, secret)
+		if errors.Is(err, database.ErrTOTPAlreadyEnabled) {
+			respondWithError(w, 409, "Two-factor authentication is already enabled", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+
+		type response struct {
+			Secret string `json:"secret"`
+			URI    string `json:"otpauth_uri"`
+		}
+		respondWithJSON(w, 200, response{Secret: secret, URI: totp.URI(totpIssuerName, user.Email, secret)})
+	})
+}
+
+func handlePostTOTPConfirm(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		id := info.UserId
+		type parameters struct {
+			Code string `json:"code"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		log.Println(rid, "handlePostTOTPConfirm for user", id)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		state, err := db.GetTOTP(id)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		if state.PendingSecret == "" {
+			respondWithError(w, 409, "No two-factor enrollment in progress", nil)
+			return
+		}
+		counter, ok := totp.Validate(state.PendingSecret, params.Code, time.Now(), totpSkew)
+		if !ok {
+			respondWithError(w, 401, "Invalid two-factor code", nil)
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			respondWithError(w, 500, "Error generating recovery codes", err)
+			return
+		}
+		err = db.ConfirmTOTPEnrollment(id, counter, hashes)
+		if err != nil {
+			respondWithError(w, 409, "Couldn't enable two-factor authentication", err)
+			return
+		}
+		log.Println(rid, "Enabled two-factor authentication for user", id)
+
+		// This is the only time the recovery codes are ever shown
+		type response struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}
+		respondWithJSON(w, 200, response{RecoveryCodes: codes})
+	})
+}
+
+func handlePostTOTPDisable(db *database.DB, auth *authenticator, throttle *loginThrottle) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		id := info.UserId
+		type parameters struct {
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		log.Println(rid, "handlePostTOTPDisable for user", id)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		// A stolen access token alone shouldn't be enough to turn off the second factor, so guessing the code is throttled like at login
+		keys := []string{mfaThrottleKey(id), ipThrottleKey(clientIP(r))}
+		attempt, wait := throttle.reserve(keys...)
+		if wait > 0 {
+			respondWithTooManyRequests(w, wait, "Too many failed two-factor attempts, try again later")
+			return
+		}
+		err = verifySecondFactor(db, id, params.Code, params.RecoveryCode)
+		if err != nil {
+			recordFailedLogin(rid, db, attempt)
+			respondWithError(w, 401, "Invalid two-factor code", err)
+			return
+		}
+		attempt.succeed(mfaThrottleKey(id))
+		err = db.DisableTOTP(id)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		log.Println(rid, "Disabled two-factor authentication for user", id)
+		w.WriteHeader(200)
+	})
+}
+
+// Completes a login that was answered with an MFA challenge, by checking either a TOTP code or a recovery code.
+func handlePostLoginMFA(db *database.DB, jwtSecret []byte, throttle *loginThrottle, respond loginResponder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		type parameters struct {
+			MFAToken     string `json:"mfa_token"`
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		claims, err := validateToken(params.MFAToken, mfaIssuer, jwtSecret)
+		if err != nil {
+			respondWithError(w, 401, "Invalid MFA token", err)
+			return
+		}
+		id, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			respondWithError(w, 401, "Given user ID is not a number", err)
+			return
+		}
+		log.Println(rid, "handlePostLoginMFA for user", id)
+
+		// Six digit codes are easy to guess without throttling
+		keys := []string{mfaThrottleKey(id), ipThrottleKey(clientIP(r))}
+		attempt, wait := throttle.reserve(keys...)
+		if wait > 0 {
+			respondWithTooManyRequests(w, wait, "Too many failed two-factor attempts, try again later")
+			return
+		}
+		err = verifySecondFactor(db, id, params.Code, params.RecoveryCode)
+		if err != nil {
+			recordFailedLogin(rid, db, attempt)
+			respondWithError(w, 401, "Invalid two-factor code", err)
+			return
+		}
+		attempt.succeed(mfaThrottleKey(id))
+		if rejectSanctioned(w, db, id) {
+			return
+		}
+
+		user, err := db.GetUser(id)
+		if err != nil {
+			respondWithError(w, 401, "User not found", err)
+			return
+		}
+		resp, err := issueLoginTokens(db, user, expirationAccessSeconds, jwtSecret, r)
+		if err != nil {
+			respondWithError(w, 500, "Error creating tokens", err)
+			return
+		}
+		respond(w, resp)
+	})
+}
+
+func mfaThrottleKey(userId int) string {
+	return fmt.Sprintf("mfa:%d", userId)
+}
+
+// Accepts either a current TOTP code or an unused recovery code, and marks it as used.
+func verifySecondFactor(db *database.DB, userId int, code, recoveryCode string) error {
+	state, err := db.GetTOTP(userId)
+	if err != nil {
+		return err
+	}
+	if !state.Enabled() {
+		return database.ErrTOTPNotEnabled
+	}
+
+	if recoveryCode != "" {
+		return db.UseRecoveryCode(userId, hashToken(normalizeRecoveryCode(recoveryCode)))
+	}
+	counter, ok := totp.Validate(state.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return errInvalidSecondFactor
+	}
+	return db.UseTOTPCounter(userId, counter)
+}
+
+// Returns fresh recovery codes of the form xxxxx-xxxxx, along with the hashes to store.
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for range recoveryCodeCount {
+		b := make([]byte, 6)
+		_, err = rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(encoding.EncodeToString(b))
+		code := s[:5] + "-" + s[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+	return codes, hashes, nil
+}
+
+// Users may type recovery codes without the dash or in upper case.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
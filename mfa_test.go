@@ -0,0 +1,161 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/password"
+	"github.com/madsbv/go-server-exercise/internal/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testJWTSecret = []byte("test-secret")
+
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+	db, err := database.NewDB(filepath.Join(t.TempDir(), "database.json"), password.NewScheme(password.Bcrypt{Cost: bcrypt.MinCost}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func newTestAuthenticator(t *testing.T, db *database.DB) *authenticator {
+	t.Helper()
+	denylist, err := newTokenDenylist(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{db: db, jwtSecret: testJWTSecret, denylist: denylist}
+}
+
+// Creates a user with TOTP enabled, and returns the user, the TOTP secret and an access token from a full login.
+func newTOTPUser(t *testing.T, db *database.DB) (database.SafeUser, string, string) {
+	t.Helper()
+	user, err := db.CreateUser("user@example.com", "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.BeginTOTPEnrollment(user.Id, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Confirmed with an older code, so that the current one hasn't been used yet
+	err = db.ConfirmTOTPEnrollment(user.Id, totp.Counter(time.Now())-10, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := issueLoginTokens(db, user, expirationAccessSeconds, testJWTSecret, httptest.NewRequest("POST", "/api/login", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, secret, resp.Token
+}
+
+func TestTOTPDisableLockout(t *testing.T) {
+	db := newTestDB(t)
+	throttle := newLoginThrottle()
+	handler := handlePostTOTPDisable(db, newTestAuthenticator(t, db), throttle)
+	user, secret, token := newTOTPUser(t, db)
+
+	disable := func(code string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/mfa/totp/disable", strings.NewReader(`{"code": "`+code+`"}`))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	// Skips the backoff between attempts, which would otherwise make the test wait
+	skipBackoff := func() {
+		throttle.mux.Lock()
+		defer throttle.mux.Unlock()
+		for _, a := range throttle.attempts {
+			if a.failures < policyFor(mfaThrottleKey(user.Id)).threshold {
+				a.blockedUntil = time.Time{}
+			}
+		}
+	}
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+
+	if w := disable(wrong); w.Code != http.StatusUnauthorized {
+		t.Fatalf("First wrong code: status %d, want 401", w.Code)
+	}
+	w := disable(wrong)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Immediate retry: status %d with Retry-After %q, want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	for i := 2; i <= accountThrottlePolicy.threshold; i++ {
+		skipBackoff()
+		if w := disable(wrong); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Wrong code %d: status %d, want 401", i, w.Code)
+		}
+	}
+	locked := false
+	for _, l := range throttle.lockedOut() {
+		locked = locked || l.Key == mfaThrottleKey(user.Id)
+	}
+	if !locked {
+		t.Errorf("%s not locked out after %d wrong codes", mfaThrottleKey(user.Id), accountThrottlePolicy.threshold)
+	}
+
+	// Once locked out, not even the right code gets through
+	skipBackoff()
+	if w := disable(code); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Right code while locked out: status %d, want 429", w.Code)
+	}
+	state, err := db.GetTOTP(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Enabled() {
+		t.Error("TOTP was disabled while locked out")
+	}
+}
+
+func TestTOTPDisableClearsFailures(t *testing.T) {
+	db := newTestDB(t)
+	throttle := newLoginThrottle()
+	handler := handlePostTOTPDisable(db, newTestAuthenticator(t, db), throttle)
+	user, secret, token := newTOTPUser(t, db)
+
+	code, err := totp.Code(secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/api/mfa/totp/disable", strings.NewReader(`{"code": "`+code+`"}`))
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Right code: status %d, want 200: %s", w.Code, w.Body)
+	}
+	state, err := db.GetTOTP(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Enabled() {
+		t.Error("TOTP still enabled")
+	}
+	throttle.mux.Lock()
+	defer throttle.mux.Unlock()
+	if _, ok := throttle.attempts[mfaThrottleKey(user.Id)]; ok {
+		t.Errorf("%s still tracked after a successful attempt", mfaThrottleKey(user.Id))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/moderation"
+)
+
+func handleGetModerationRules(filter *moderation.Filter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		respondWithJSON(w, 200, filter.Rules())
+	})
+}
+
+func handlePostModerationRule(filter *moderation.Filter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		decoder := json.NewDecoder(r.Body)
+		rule := moderation.Rule{}
+		err := decoder.Decode(&rule)
+		log.Println(rid, "handlePostModerationRule", rule.Id, rule.Action)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+		err = filter.AddRule(rule)
+		if err != nil {
+			respondWithRuleError(w, err)
+			return
+		}
+		respondWithJSON(w, 201, rule)
+	})
+}
+
+// Replaces the rule with the id in the path.
+func handlePutModerationRule(filter *moderation.Filter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		decoder := json.NewDecoder(r.Body)
+		rule := moderation.Rule{}
+		err := decoder.Decode(&rule)
+		rule.Id = r.PathValue("id")
+		log.Println(rid, "handlePutModerationRule", rule.Id, rule.Action)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+		err = filter.UpdateRule(rule)
+		if err != nil {
+			respondWithRuleError(w, err)
+			return
+		}
+		respondWithJSON(w, 200, rule)
+	})
+}
+
+func handleDeleteModerationRule(filter *moderation.Filter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		id := r.PathValue("id")
+		log.Println(rid, "handleDeleteModerationRule", id)
+		err := filter.DeleteRule(id)
+		if err != nil {
+			respondWithRuleError(w, err)
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+// Picks up changes made to the rules file by hand. An invalid file is reported and leaves the current rules in place.
+func handlePostModerationReload(filter *moderation.Filter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Println(getRequestID(w), "handlePostModerationReload")
+		err := filter.Reload()
+		if err != nil {
+			respondWithError(w, 500, "Failed to reload rules: "+err.Error(), err)
+			return
+		}
+		respondWithJSON(w, 200, filter.Rules())
+	})
+}
+
+func respondWithRuleError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, moderation.ErrRuleNotFound):
+		respondWithError(w, 404, err.Error(), err)
+	case errors.Is(err, moderation.ErrRuleExists):
+		respondWithError(w, 409, err.Error(), err)
+	case errors.Is(err, moderation.ErrInvalidRule):
+		respondWithError(w, 400, err.Error(), err)
+	default:
+		respondWithError(w, 500, "Error saving rules", err)
+	}
+}
+
+func handleGetFlaggedChirps(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flagged, err := db.GetFlaggedChirps()
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, flagged)
+	})
+}
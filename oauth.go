@@ -0,0 +1,484 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+const expirationAuthorizationCodeSeconds = 60 * 5 // 5 minutes
+
+const grantTypeAuthorizationCode = "authorization_code"
+const grantTypeRefreshToken = "refresh_token"
+const grantTypeClientCredentials = "client_credentials"
+
+// Shown on the consent page, so that users know what they are agreeing to
+var scopeDescriptions = map[string]string{
+	scopeChirpsRead:   "Read chirps on your behalf",
+	scopeChirpsWrite:  "Post and delete chirps as you",
+	scopeProfileWrite: "Follow and unfollow users as you",
+}
+
+// An error as defined by RFC 6749, reported to the client either through the redirect URI or in the token endpoint response.
+type oauthError struct {
+	Code        string
+	Description string
+}
+
+func (e *oauthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+type authorizationRequest struct {
+	Client        database.OAuthClient
+	RedirectURI   string
+	Scopes        []string
+	State         string
+	CodeChallenge string
+}
+
+// Checks the parameters of an authorization request. If the returned request has no redirect URI, the client couldn't be identified and the error must be shown to the user instead of being sent to the client.
+func parseAuthorizationRequest(db *database.DB, values url.Values) (authorizationRequest, error) {
+	req := authorizationRequest{}
+	client, err := db.GetOAuthClient(values.Get("client_id"))
+	if err != nil {
+		return req, err
+	}
+	req.Client = client
+
+	// Redirecting anywhere but a registered URI would hand the code to whoever crafted the link. The URI is always required and compared as an exact string, as in OAuth 2.1, rather than defaulting to the only registered one or allowing variations of it
+	redirectURI := values.Get("redirect_uri")
+	if redirectURI == "" {
+		return req, errors.New("Redirect URI is required")
+	}
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return req, errors.New("Redirect URI is not registered for this client")
+	}
+	req.RedirectURI = redirectURI
+	req.State = values.Get("state")
+
+	if values.Get("response_type") != "code" {
+		return req, &oauthError{"unsupported_response_type", "Only the code response type is supported"}
+	}
+	req.CodeChallenge = values.Get("code_challenge")
+	if req.CodeChallenge == "" {
+		return req, &oauthError{"invalid_request", "PKCE code_challenge is required"}
+	}
+	if values.Get("code_challenge_method") != "S256" {
+		return req, &oauthError{"invalid_request", "code_challenge_method must be S256"}
+	}
+
+	req.Scopes, err = parseRequestedScopes(values.Get("scope"), client)
+	if err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+// Parses a space separated scope parameter, defaulting to every scope the client is registered for.
+func parseRequestedScopes(scope string, client database.OAuthClient) ([]string, error) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return client.Scopes, nil
+	}
+	for _, s := range scopes {
+		if !slices.Contains(client.Scopes, s) {
+			return nil, &oauthError{"invalid_scope", fmt.Sprintf("Client is not registered for scope %q", s)}
+		}
+	}
+	slices.Sort(scopes)
+	return slices.Compact(scopes), nil
+}
+
+// Sends the browser back to the client with the given parameters added to the redirect URI.
+func redirectToClient(w http.ResponseWriter, r *http.Request, req authorizationRequest, params url.Values) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		respondWithError(w, 500, "Invalid redirect URI", err)
+		return
+	}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	query := u.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	u.RawQuery = query.Encode()
+	http.Redirect(w, r, u.String(), http.StatusSeeOther)
+}
+
+// Reports a problem with the authorization request, to the client if it can be trusted and otherwise to the user.
+func respondWithAuthorizationError(w http.ResponseWriter, r *http.Request, req authorizationRequest, err error) {
+	var oerr *oauthError
+	if req.RedirectURI == "" || !errors.As(err, &oerr) {
+		log.Println(getRequestID(w), "Rejected authorization request:", err)
+		renderPage(w, 400, errorPage, err.Error())
+		return
+	}
+	redirectToClient(w, r, req, url.Values{"error": {oerr.Code}, "error_description": {oerr.Description}})
+}
+
+func handleGetAuthorize(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		req, err := parseAuthorizationRequest(db, r.URL.Query())
+		if err != nil {
+			respondWithAuthorizationError(w, r, req, err)
+			return
+		}
+		log.Println(rid, "handleGetAuthorize for client", req.Client.Id, req.Scopes)
+		renderConsentPage(w, 200, req, "", "")
+	})
+}
+
+// Handles the consent form. Users sign in with their password, and their second factor if enabled, before a code is issued.
+func handlePostAuthorize(db *database.DB, throttle *loginThrottle) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		err := r.ParseForm()
+		if err != nil {
+			respondWithError(w, 400, "Failed to parse form", err)
+			return
+		}
+		req, err := parseAuthorizationRequest(db, r.PostForm)
+		if err != nil {
+			respondWithAuthorizationError(w, r, req, err)
+			return
+		}
+		log.Println(rid, "handlePostAuthorize for client", req.Client.Id)
+
+		if r.PostFormValue("decision") != "allow" {
+			redirectToClient(w, r, req, url.Values{"error": {"access_denied"}})
+			return
+		}
+
+		user, status, msg := checkFormLogin(w, r, db, throttle)
+		if status != 0 {
+			renderConsentPage(w, status, req, r.PostFormValue("email"), msg)
+			return
+		}
+
+		code, err := newRandomToken()
+		if err != nil {
+			respondWithError(w, 500, "Error generating authorization code", err)
+			return
+		}
+		err = db.CreateAuthorizationCode(hashToken(code), database.AuthorizationCode{
+			ClientId:      req.Client.Id,
+			UserId:        user.Id,
+			RedirectURI:   req.RedirectURI,
+			Scopes:        req.Scopes,
+			CodeChallenge: req.CodeChallenge,
+			ExpiresAt:     time.Now().Add(expirationAuthorizationCodeSeconds * time.Second),
+		})
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		log.Println(rid, "User", user.Id, "authorized client", req.Client.Id, req.Scopes)
+		redirectToClient(w, r, req, url.Values{"code": {code}})
+	})
+}
+
+// Checks the credentials submitted on one of the browser pages, including the second factor if the user has one. If they are rejected, returns the status and message to show on the page.
+func checkFormLogin(w http.ResponseWriter, r *http.Request, db *database.DB, throttle *loginThrottle) (database.SafeUser, int, string) {
+	rid := getRequestID(w)
+	email := r.PostFormValue("email")
+	accountKey := accountThrottleKey(email)
+	keys := []string{accountKey, ipThrottleKey(clientIP(r))}
+	attempt, wait := throttle.reserve(keys...)
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		return database.SafeUser{}, 429, "Too many failed login attempts, try again later"
+	}
+	user, err := db.ValidateLogin(email, r.PostFormValue("password"))
+	if err != nil {
+		log.Println(rid, "Error while validating login", err)
+		recordFailedLogin(rid, db, attempt)
+		return database.SafeUser{}, 401, "Incorrect email or password"
+	}
+	attempt.succeed(accountKey)
+	err = checkSanction(db, user.Id)
+	var serr *sanctionError
+	if errors.As(err, &serr) {
+		return database.SafeUser{}, 403, serr.details()
+	}
+	if err != nil {
+		log.Println(rid, "Error checking sanctions", err)
+		return database.SafeUser{}, 500, "Something went wrong, please try again"
+	}
+
+	totpState, err := db.GetTOTP(user.Id)
+	if err != nil {
+		log.Println(rid, "Error getting two-factor state", err)
+		return database.SafeUser{}, 500, "Something went wrong, please try again"
+	}
+	if !totpState.Enabled() {
+		return user, 0, ""
+	}
+	mfaKeys := []string{mfaThrottleKey(user.Id), ipThrottleKey(clientIP(r))}
+	mfaAttempt, wait := throttle.reserve(mfaKeys...)
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		return database.SafeUser{}, 429, "Too many failed two-factor attempts, try again later"
+	}
+	// The same field takes either a TOTP code or a recovery code
+	code, recoveryCode := r.PostFormValue("code"), ""
+	if len(normalizeRecoveryCode(code)) == 10 {
+		code, recoveryCode = "", code
+	}
+	err = verifySecondFactor(db, user.Id, code, recoveryCode)
+	if err != nil {
+		recordFailedLogin(rid, db, mfaAttempt)
+		return database.SafeUser{}, 401, "Enter a valid two-factor or recovery code"
+	}
+	mfaAttempt.succeed(mfaThrottleKey(user.Id))
+	return user, 0, ""
+}
+
+// Exchanges grants for tokens, following RFC 6749. Supports the authorization code grant with PKCE, refresh tokens, device codes, and client credentials for confidential clients acting as the user who registered them.
+func handlePostOAuthToken(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		w.Header().Set("Cache-Control", "no-store")
+
+		client, ok := authenticateClient(w, r, db)
+		if !ok {
+			return
+		}
+		grantType := r.PostFormValue("grant_type")
+		log.Println(rid, "handlePostOAuthToken", grantType, "for client", client.Id)
+
+		var resp tokenResponse
+		var err error
+		switch grantType {
+		case grantTypeAuthorizationCode:
+			resp, err = exchangeAuthorizationCode(db, auth.jwtSecret, client, r)
+		case grantTypeRefreshToken:
+			resp, err = exchangeRefreshToken(db, auth, client, r)
+		case grantTypeClientCredentials:
+			resp, err = exchangeClientCredentials(db, auth.jwtSecret, client, r)
+		case grantTypeDeviceCode:
+			resp, err = exchangeDeviceCode(db, auth.jwtSecret, client, r)
+		default:
+			err = &oauthError{"unsupported_grant_type", fmt.Sprintf("Grant type %q is not supported", grantType)}
+		}
+		var oerr *oauthError
+		if errors.As(err, &oerr) {
+			respondWithOAuthError(w, 400, oerr)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Error creating tokens", err)
+			return
+		}
+		respondWithJSON(w, 200, resp)
+	})
+}
+
+// Authenticates the client with HTTP Basic auth or form parameters, or responds with an error and returns false. Public clients only send their ID.
+func authenticateClient(w http.ResponseWriter, r *http.Request, db *database.DB) (database.OAuthClient, bool) {
+	clientId, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientId, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	secretHash := ""
+	if clientSecret != "" {
+		secretHash = hashToken(clientSecret)
+	}
+	client, err := db.AuthenticateOAuthClient(clientId, secretHash)
+	if err != nil {
+		log.Println(getRequestID(w), "Client authentication failed for", clientId, err)
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(w, 401, &oauthError{"invalid_client", "Client authentication failed"})
+		return database.OAuthClient{}, false
+	}
+	return client, true
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope"`
+}
+
+func exchangeAuthorizationCode(db *database.DB, jwtSecret []byte, client database.OAuthClient, r *http.Request) (tokenResponse, error) {
+	code, err := db.ConsumeAuthorizationCode(hashToken(r.PostFormValue("code")))
+	if errors.Is(err, database.ErrInvalidAuthorizationCode) {
+		return tokenResponse{}, &oauthError{"invalid_grant", "Authorization code is invalid or expired"}
+	}
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if code.ClientId != client.Id {
+		return tokenResponse{}, &oauthError{"invalid_grant", "Authorization code was issued to another client"}
+	}
+	if r.PostFormValue("redirect_uri") != code.RedirectURI {
+		return tokenResponse{}, &oauthError{"invalid_grant", "Redirect URI doesn't match the authorization request"}
+	}
+	if !verifyCodeChallenge(r.PostFormValue("code_verifier"), code.CodeChallenge) {
+		return tokenResponse{}, &oauthError{"invalid_grant", "Code verifier doesn't match the code challenge"}
+	}
+
+	scope := strings.Join(code.Scopes, " ")
+	access, refresh, err := issueSessionTokens(db, code.UserId, &tokenClaims{Scope: scope, ClientId: client.Id}, expirationAccessSeconds, jwtSecret, r)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	return tokenResponse{AccessToken: access, TokenType: "Bearer", ExpiresIn: expirationAccessSeconds, RefreshToken: refresh, Scope: scope}, nil
+}
+
+// Checks a PKCE code verifier against the S256 challenge from the authorization request, see RFC 7636.
+func verifyCodeChallenge(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func exchangeRefreshToken(db *database.DB, auth *authenticator, client database.OAuthClient, r *http.Request) (tokenResponse, error) {
+	tokenString := r.PostFormValue("refresh_token")
+	info, err := auth.inspect(tokenString, false)
+	if err != nil || info.TokenType != tokenTypeRefresh || info.ClientId != client.Id {
+		return tokenResponse{}, &oauthError{"invalid_grant", "Refresh token is invalid, revoked or was issued to another client"}
+	}
+	claims, err := validateToken(tokenString, refreshIssuer, auth.jwtSecret)
+	if err != nil {
+		return tokenResponse{}, &oauthError{"invalid_grant", "Refresh token is invalid"}
+	}
+	_, err = db.UseRefreshToken(tokenString, clientIP(r), r.UserAgent())
+	if errors.Is(err, database.ErrSessionNotFound) {
+		return tokenResponse{}, &oauthError{"invalid_grant", "Session not found"}
+	}
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	access, err := refreshAccessToken(*claims, auth.jwtSecret)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	return tokenResponse{AccessToken: access, TokenType: "Bearer", ExpiresIn: expirationAccessSeconds, Scope: claims.Scope}, nil
+}
+
+// Service accounts authenticate as the user who registered the client. There is no session, so no refresh token either.
+func exchangeClientCredentials(db *database.DB, jwtSecret []byte, client database.OAuthClient, r *http.Request) (tokenResponse, error) {
+	if !client.Confidential {
+		return tokenResponse{}, &oauthError{"unauthorized_client", "Public clients can't use the client credentials grant"}
+	}
+	scopes, err := parseRequestedScopes(r.PostFormValue("scope"), client)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	generation, err := db.GetTokenGeneration(client.OwnerId)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	scope := strings.Join(scopes, " ")
+	claims := tokenClaims{Generation: generation, Scope: scope, ClientId: client.Id}
+	access, err := newTokenWithClaims(claims, fmt.Sprint(client.OwnerId), accessIssuer, expirationAccessSeconds, jwtSecret)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	return tokenResponse{AccessToken: access, TokenType: "Bearer", ExpiresIn: expirationAccessSeconds, Scope: scope}, nil
+}
+
+func respondWithOAuthError(w http.ResponseWriter, code int, err *oauthError) {
+	log.Println(getRequestID(w), "Responding with OAuth error", err)
+	type response struct {
+		Error       string `json:"error"`
+		Description string `json:"error_description,omitempty"`
+	}
+	respondWithJSON(w, code, response{Error: err.Code, Description: err.Description})
+}
+
+var consentPage = template.Must(template.New("consent").Parse(`<html>
+  <head>
+    <title>Authorize {{.Client.Name}}</title>
+  </head>
+  <body>
+    <h1>Authorize {{.Client.Name}}</h1>
+    <p>{{.Client.Name}} would like to:</p>
+    <ul>
+      {{- range .Scopes}}
+      <li>{{.}}</li>
+      {{- end}}
+    </ul>
+    {{- if .Error}}
+    <p><strong>{{.Error}}</strong></p>
+    {{- end}}
+    <form method="post" action="/oauth/authorize">
+      <input type="hidden" name="response_type" value="code">
+      <input type="hidden" name="client_id" value="{{.Client.Id}}">
+      <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+      <input type="hidden" name="scope" value="{{.Scope}}">
+      <input type="hidden" name="state" value="{{.State}}">
+      <input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+      <input type="hidden" name="code_challenge_method" value="S256">
+      <p><label>Email <input type="email" name="email" value="{{.Email}}" required></label></p>
+      <p><label>Password <input type="password" name="password" required></label></p>
+      <p><label>Two-factor or recovery code, if enabled <input type="text" name="code" autocomplete="one-time-code"></label></p>
+      <button type="submit" name="decision" value="allow">Allow</button>
+      <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+    </form>
+  </body>
+</html>
+`))
+
+var errorPage = template.Must(template.New("error").Parse(`<html>
+  <head>
+    <title>Authorization failed</title>
+  </head>
+  <body>
+    <h1>Authorization failed</h1>
+    <p>{{.}}</p>
+  </body>
+</html>
+`))
+
+func renderConsentPage(w http.ResponseWriter, code int, req authorizationRequest, email, errMsg string) {
+	scopes := make([]string, len(req.Scopes))
+	for i, s := range req.Scopes {
+		scopes[i] = scopeDescriptions[s]
+	}
+	renderPage(w, code, consentPage, map[string]any{
+		"Client":        req.Client,
+		"RedirectURI":   req.RedirectURI,
+		"Scope":         strings.Join(req.Scopes, " "),
+		"Scopes":        scopes,
+		"State":         req.State,
+		"CodeChallenge": req.CodeChallenge,
+		"Email":         email,
+		"Error":         errMsg,
+	})
+}
+
+func renderPage(w http.ResponseWriter, code int, page *template.Template, data any) {
+	// Pages that take credentials must not be framed by other sites, or users could be tricked into approving a client
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	err := page.Execute(w, data)
+	if err != nil {
+		log.Println(getRequestID(w), "Error rendering page", err)
+	}
+}
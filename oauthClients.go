@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+func handlePostOAuthClients(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		type parameters struct {
+			Name         string   `json:"name"`
+			RedirectURIs []string `json:"redirect_uris"`
+			Scopes       []string `json:"scopes"`
+			// Confidential clients run on a server and can keep a secret, public ones can't
+			Confidential bool `json:"confidential"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		log.Println(rid, "handlePostOAuthClients for user", info.UserId, params.Name, params.Scopes)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		name := strings.TrimSpace(params.Name)
+		if name == "" {
+			respondWithError(w, 400, "Client name is required", nil)
+			return
+		}
+		if len(params.Scopes) == 0 {
+			respondWithError(w, 400, fmt.Sprintf("At least one scope is required, choose from %v", grantableScopes), nil)
+			return
+		}
+		for _, s := range params.Scopes {
+			if !slices.Contains(grantableScopes, s) {
+				respondWithError(w, 400, fmt.Sprintf("Unknown scope %q, choose from %v", s, grantableScopes), nil)
+				return
+			}
+		}
+		// Public clients can only use the authorization code grant, which needs somewhere to send the code
+		if !params.Confidential && len(params.RedirectURIs) == 0 {
+			respondWithError(w, 400, "Public clients need at least one redirect URI", nil)
+			return
+		}
+		for _, uri := range params.RedirectURIs {
+			err = validateRedirectURI(uri)
+			if err != nil {
+				respondWithError(w, 400, fmt.Sprintf("Invalid redirect URI %q: %v", uri, err), err)
+				return
+			}
+		}
+
+		id, err := newRandomId()
+		if err != nil {
+			respondWithError(w, 500, "Error generating client ID", err)
+			return
+		}
+		var secret, secretHash string
+		if params.Confidential {
+			secret, err = newRandomToken()
+			if err != nil {
+				respondWithError(w, 500, "Error generating client secret", err)
+				return
+			}
+			secretHash = hashToken(secret)
+		}
+		scopes := slices.Clone(params.Scopes)
+		slices.Sort(scopes)
+		scopes = slices.Compact(scopes)
+		redirectURIs := slices.Clone(params.RedirectURIs)
+		if redirectURIs == nil {
+			redirectURIs = []string{}
+		}
+		client, err := db.CreateOAuthClient(id, info.UserId, name, secretHash, redirectURIs, scopes)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+
+		// This is the only time the secret itself is ever shown
+		type response struct {
+			database.OAuthClient
+			Secret string `json:"client_secret,omitempty"`
+		}
+		respondWithJSON(w, 201, response{OAuthClient: client, Secret: secret})
+	})
+}
+
+// Redirect URIs must be absolute and without fragments. Plain HTTP is only allowed for loopback addresses, as used by native apps during development.
+func validateRedirectURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return err
+	}
+	if u.Fragment != "" {
+		return errors.New("must not contain a fragment")
+	}
+	switch u.Scheme {
+	case "https":
+	case "http":
+		host := u.Hostname()
+		if host != "localhost" && host != "127.0.0.1" && host != "::1" {
+			return errors.New("plain HTTP is only allowed for loopback addresses")
+		}
+	default:
+		return errors.New("must use HTTPS")
+	}
+	if u.Host == "" {
+		return errors.New("must be absolute")
+	}
+	return nil
+}
+
+func handleGetOAuthClients(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		clients, err := db.GetOAuthClients(info.UserId)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, clients)
+	})
+}
+
+// Deleting a client revokes every token that was granted to it.
+func handleDeleteOAuthClient(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		id := r.PathValue("id")
+		log.Println(rid, "handleDeleteOAuthClient", id, "for user", info.UserId)
+
+		err := db.DeleteOAuthClient(info.UserId, id)
+		if errors.Is(err, database.ErrClientNotFound) {
+			respondWithError(w, 404, "Client not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/mailer"
+	"github.com/madsbv/go-server-exercise/internal/password"
+)
+
+const expirationResetSeconds = 60 * 30 // 30 minutes
+
+// Reset emails allowed per hour, so that nobody can flood an inbox or the mail relay
+const resetRateLimitPerIP = 10
+const resetRateLimitPerEmail = 3
+
+// Returns a random, URL safe token with 256 bits of entropy.
+func newRandomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Random tokens are stored hashed, so that a leaked database doesn't hand out working tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func handlePostPasswordForgot(db *database.DB, m mailer.Mailer, ipLimiter, emailLimiter *rateLimiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		type parameters struct {
+			Email string `json:"email"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		log.Println(rid, "handlePostPasswordForgot", params.Email)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		// Requests over the limit are dropped silently, as telling them apart would reveal which emails have accounts
+		if !ipLimiter.allow(clientIP(r)) || !emailLimiter.allow(strings.ToLower(strings.TrimSpace(params.Email))) {
+			log.Println(rid, "Rate limited password reset for", params.Email)
+			w.WriteHeader(202)
+			return
+		}
+
+		// The response is the same whether or not the account exists, and the work happens in the background so that the timing is too.
+		go func() {
+			err := sendPasswordReset(db, m, params.Email)
+			if err != nil {
+				log.Println(rid, "Not sending password reset to", params.Email, err)
+			}
+		}()
+		w.WriteHeader(202)
+	})
+}
+
+func sendPasswordReset(db *database.DB, m mailer.Mailer, email string) error {
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		return err
+	}
+	err = db.CreatePasswordReset(hashToken(token), database.PasswordReset{
+		UserId:    user.Id,
+		ExpiresAt: time.Now().Add(expirationResetSeconds * time.Second),
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf(`Someone asked to reset the password of your Chirpy account.
+
+To choose a new password, send the following token along with your new password to POST /api/password/reset within 30 minutes:
+
+%s
+
+The token can only be used once. If you didn't ask for a password reset, you can ignore this email.
+`, token),
+	})
+}
+
+func handlePostPasswordReset(db *database.DB, policy *password.Policy) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		type parameters struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		log.Println(rid, "handlePostPasswordReset")
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		tokenHash := hashToken(params.Token)
+		user, err := db.GetPasswordResetUser(tokenHash)
+		if err != nil {
+			respondWithResetError(w, err)
+			return
+		}
+
+		err = policy.Validate(user.Email, params.Password)
+		if err != nil {
+			respondWithPolicyError(w, err)
+			return
+		}
+
+		user, err = db.ResetPassword(tokenHash, params.Password)
+		if err != nil {
+			respondWithResetError(w, err)
+			return
+		}
+		log.Println(rid, "Reset password and revoked refresh tokens for user", user.Id)
+		respondWithJSON(w, 200, user)
+	})
+}
+
+func respondWithResetError(w http.ResponseWriter, err error) {
+	if errors.Is(err, database.ErrInvalidResetToken) {
+		respondWithError(w, 401, "Invalid or expired reset token", err)
+		return
+	}
+	respondWithError(w, 500, "Potential database error", err)
+}
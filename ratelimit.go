@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Allows each key a number of events per sliding window, such as signups per IP.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+	mux    sync.Mutex
+}
+
+// A limit of 0 disables the limiter.
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: make(map[string][]time.Time)}
+}
+
+// Returns how long the key has to wait before its next event is allowed, or 0 if it is allowed now.
+func (rl *rateLimiter) retryAfter(key string) time.Duration {
+	if rl.limit <= 0 {
+		return 0
+	}
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+	events := rl.prune(key, time.Now())
+	if len(events) < rl.limit {
+		return 0
+	}
+	return time.Until(events[0].Add(rl.window))
+}
+
+// Records an event for the key if it is within the limit, checking and recording in one step so that concurrent requests can't all slip through. Returns whether the event was allowed.
+func (rl *rateLimiter) allow(key string) bool {
+	if rl.limit <= 0 {
+		return true
+	}
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+	now := time.Now()
+	events := rl.prune(key, now)
+	if len(events) >= rl.limit {
+		return false
+	}
+	rl.events[key] = append(events, now)
+	return true
+}
+
+// Takes back the latest event of the key, for events that were allowed but turned out not to happen, such as signups that failed.
+func (rl *rateLimiter) release(key string) {
+	if rl.limit <= 0 {
+		return
+	}
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+	events := rl.prune(key, time.Now())
+	if len(events) == 0 {
+		return
+	}
+	if len(events) == 1 {
+		delete(rl.events, key)
+		return
+	}
+	rl.events[key] = events[:len(events)-1]
+}
+
+// Drops the events of the key that have left the window. Must be called with the lock held.
+func (rl *rateLimiter) prune(key string, now time.Time) []time.Time {
+	events := rl.events[key]
+	i := 0
+	for i < len(events) && now.Sub(events[i]) >= rl.window {
+		i++
+	}
+	events = events[i:]
+	if len(events) == 0 {
+		delete(rl.events, key)
+		return nil
+	}
+	rl.events[key] = events
+	return events
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int
+		events  int
+		aged    int
+		allowed bool
+	}{
+		{"first event", 3, 0, 0, true},
+		{"below the limit", 3, 2, 0, true},
+		{"at the limit", 3, 3, 0, false},
+		{"events left the window", 3, 3, 1, true},
+		{"disabled", 0, 10, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := newRateLimiter(tt.limit, time.Hour)
+			for i := range tt.events {
+				at := time.Now()
+				if i < tt.aged {
+					at = at.Add(-time.Hour)
+				}
+				rl.events["ip"] = append(rl.events["ip"], at)
+			}
+
+			wait := rl.retryAfter("ip")
+			if (wait == 0) != tt.allowed {
+				t.Errorf("retryAfter = %v, want allowed %v", wait, tt.allowed)
+			}
+			if tt.allowed && wait > time.Hour {
+				t.Errorf("retryAfter = %v, longer than the window", wait)
+			}
+			if allowed := rl.allow("ip"); allowed != tt.allowed {
+				t.Errorf("allow = %v, want %v", allowed, tt.allowed)
+			}
+			if !rl.allow("other ip") {
+				t.Error("Other key limited as well")
+			}
+		})
+	}
+}
+
+func TestRateLimiterRelease(t *testing.T) {
+	rl := newRateLimiter(2, time.Hour)
+	rl.allow("ip")
+	rl.allow("ip")
+	if rl.allow("ip") {
+		t.Fatal("Event over the limit allowed")
+	}
+	rl.release("ip")
+	if !rl.allow("ip") {
+		t.Error("Event not allowed after releasing one")
+	}
+
+	rl.release("ip")
+	rl.release("ip")
+	rl.release("ip")
+	if _, ok := rl.events["ip"]; ok {
+		t.Errorf("Events left after releasing all of them: %v", rl.events["ip"])
+	}
+}
+
+func TestRateLimiterConcurrent(t *testing.T) {
+	const limit = 5
+	rl := newRateLimiter(limit, time.Hour)
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	allowed := 0
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rl.allow("ip") {
+				mux.Lock()
+				allowed++
+				mux.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != limit {
+		t.Errorf("Allowed %d events, want %d", allowed, limit)
+	}
+}
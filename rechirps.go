@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+func handlePostRechirp(db *database.DB, auth *authenticator, requireVerifiedEmail bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeChirpsWrite)
+		if !ok {
+			return
+		}
+		chirpId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given chirp ID is not a number", err)
+			return
+		}
+		log.Println(rid, "handlePostRechirp", chirpId, "for user", info.UserId)
+		if !requireVerified(w, db, requireVerifiedEmail, info.UserId) {
+			return
+		}
+
+		chirp, err := db.Rechirp(chirpId, info.UserId)
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, 404, "Couldn't retrieve chirp", err)
+			return
+		}
+		if errors.Is(err, database.ErrAlreadyRechirped) {
+			respondWithError(w, 409, err.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 201, chirp)
+	})
+}
+
+func handleDeleteRechirp(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeChirpsWrite)
+		if !ok {
+			return
+		}
+		chirpId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given chirp ID is not a number", err)
+			return
+		}
+		log.Println(rid, "handleDeleteRechirp", chirpId, "for user", info.UserId)
+
+		err = db.Unrechirp(chirpId, info.UserId)
+		if errors.Is(err, database.ErrRechirpNotFound) {
+			respondWithError(w, 404, err.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/trends"
+)
+
+const maxReportReasonLength = 500
+
+func handlePostReport(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeChirpsWrite)
+		if !ok {
+			return
+		}
+		chirpId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given chirp ID is not a number", err)
+			return
+		}
+		type parameters struct {
+			Reason string `json:"reason"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err = decoder.Decode(&params)
+		log.Println(rid, "handlePostReport", chirpId, "by user", info.UserId)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+		reason := strings.TrimSpace(params.Reason)
+		if reason == "" {
+			respondWithError(w, 400, "A reason is required", nil)
+			return
+		}
+		if len(reason) > maxReportReasonLength {
+			respondWithError(w, 400, "Reason is too long", nil)
+			return
+		}
+
+		chirp, err := db.GetChirp(chirpId)
+		if err != nil {
+			respondWithError(w, 404, "Couldn't retrieve chirp", err)
+			return
+		}
+		if chirp.AuthorId == info.UserId {
+			respondWithError(w, 400, "Users can't report their own chirps", nil)
+			return
+		}
+		report, err := db.ReportChirp(chirpId, info.UserId, reason)
+		if errors.Is(err, database.ErrChirpNotFound) {
+			respondWithError(w, 404, "Couldn't retrieve chirp", err)
+			return
+		}
+		if errors.Is(err, database.ErrAlreadyReported) {
+			respondWithError(w, 409, err.Error(), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 201, report)
+	})
+}
+
+// Moderating takes a full login by a user that an admin has made a moderator, rather than just any token.
+func requireModerator(w http.ResponseWriter, r *http.Request, auth *authenticator, db *database.DB) (authInfo, bool) {
+	info, ok := auth.require(w, r, scopeAccount)
+	if !ok {
+		return info, false
+	}
+	moderator, err := db.IsModerator(info.UserId)
+	if err != nil {
+		respondWithError(w, 500, "Potential database error", err)
+		return info, false
+	}
+	if !moderator {
+		respondWithError(w, 403, "Only moderators can do this", nil)
+		return info, false
+	}
+	return info, true
+}
+
+// Lists open reports by default. The status parameter selects claimed or resolved reports instead, or all of them.
+func handleGetReports(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := requireModerator(w, r, auth, db); !ok {
+			return
+		}
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "":
+			status = database.ReportOpen
+		case "all":
+			status = ""
+		case database.ReportOpen, database.ReportClaimed, database.ReportResolved:
+		default:
+			respondWithError(w, 400, "status must be open, claimed, resolved or all", nil)
+			return
+		}
+		reports, err := db.GetReports(status)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, reports)
+	})
+}
+
+func handlePostClaimReport(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := requireModerator(w, r, auth, db)
+		if !ok {
+			return
+		}
+		reportId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given report ID is not a number", err)
+			return
+		}
+		log.Println(rid, "handlePostClaimReport", reportId, "by moderator", info.UserId)
+
+		report, err := db.ClaimReport(reportId, info.UserId)
+		if err != nil {
+			respondWithReportError(w, err)
+			return
+		}
+		respondWithJSON(w, 200, report)
+	})
+}
+
+func handlePostResolveReport(db *database.DB, auth *authenticator, tracker *trends.Tracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := requireModerator(w, r, auth, db)
+		if !ok {
+			return
+		}
+		reportId, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given report ID is not a number", err)
+			return
+		}
+		type parameters struct {
+			Resolution string `json:"resolution"`
+			Note       string `json:"note"`
+			// Only for suspensions
+			Duration int `json:"duration_seconds"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err = decoder.Decode(&params)
+		log.Println(rid, "handlePostResolveReport", reportId, params.Resolution, "by moderator", info.UserId)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		note := strings.TrimSpace(params.Note)
+		var suspendUntil time.Time
+		switch params.Resolution {
+		case database.ResolutionDismiss, database.ResolutionDeleteChirp:
+		case database.ResolutionSuspendAuthor:
+			// The note is shown to the author as the reason for their suspension
+			if note == "" {
+				respondWithError(w, 400, "Suspensions need a note explaining the reason", nil)
+				return
+			}
+			if params.Duration <= 0 {
+				respondWithError(w, 400, "Suspensions need a positive duration_seconds", nil)
+				return
+			}
+			suspendUntil = time.Now().Add(time.Duration(params.Duration) * time.Second)
+		default:
+			respondWithError(w, 400, "resolution must be dismiss, delete_chirp or suspend_author", nil)
+			return
+		}
+
+		report, err := db.ResolveReport(reportId, info.UserId, params.Resolution, note, suspendUntil)
+		if err != nil {
+			respondWithReportError(w, err)
+			return
+		}
+		if params.Resolution != database.ResolutionDismiss {
+			refreshTrends(w, db, tracker)
+		}
+		respondWithJSON(w, 200, report)
+	})
+}
+
+func respondWithReportError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, database.ErrReportNotFound), errors.Is(err, database.ErrChirpNotFound), errors.Is(err, database.ErrUserNotFound):
+		respondWithError(w, 404, err.Error(), err)
+	case errors.Is(err, database.ErrReportClaimed), errors.Is(err, database.ErrReportNotClaimed), errors.Is(err, database.ErrReportResolved), errors.Is(err, database.ErrAuthorBanned):
+		respondWithError(w, 409, err.Error(), err)
+	default:
+		respondWithError(w, 500, "Potential database error", err)
+	}
+}
+
+func handlePutModerator(db *database.DB) http.Handler {
+	return handleSetModerator(db, true)
+}
+
+func handleDeleteModerator(db *database.DB) http.Handler {
+	return handleSetModerator(db, false)
+}
+
+func handleSetModerator(db *database.DB, moderator bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given user ID is not a number", err)
+			return
+		}
+		log.Println(rid, "handleSetModerator", id, moderator)
+
+		err = db.SetModerator(id, moderator)
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, 404, "User not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handleGetModerationLog(db *database.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actions, err := db.GetModerationLog()
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, actions)
+	})
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/madsbv/go-server-exercise/internal/database"
 )
 
 func initRoutes(db *database.DB, apiCfg *apiConfig, filepathRoot string) *http.ServeMux {
 	smux := http.NewServeMux()
+	throttle := newLoginThrottle()
+	go throttle.runSweep(10 * time.Minute)
+	signupLimiter := newRateLimiter(apiCfg.signupRateLimit, time.Hour)
+	auth := &authenticator{db: db, jwtSecret: apiCfg.jwtSecret, denylist: apiCfg.tokenDenylist}
 
 	smux.Handle(filepathRoot, apiCfg.middlewareMetricsInc(http.FileServer(http.Dir("."))))
 
@@ -15,21 +20,102 @@ func initRoutes(db *database.DB, apiCfg *apiConfig, filepathRoot string) *http.S
 	smux.HandleFunc("GET /admin/metrics", apiCfg.metrics)
 	smux.HandleFunc("GET /api/reset", apiCfg.reset)
 
-	smux.Handle("POST /api/chirps", handlePostChirps(db, apiCfg.jwtSecret))
-	smux.Handle("GET /api/chirps", handleGetAllChirps(db))
-	smux.Handle("GET /api/chirps/{id}", handleGetChirp(db))
-	smux.Handle("DELETE /api/chirps/{id}", handleDeleteChirp(db, apiCfg.jwtSecret))
+	smux.Handle("POST /api/chirps", handlePostChirps(db, auth, apiCfg.requireVerifiedEmail, apiCfg.trends, apiCfg.moderation))
+	smux.Handle("GET /api/chirps", handleGetAllChirps(db, auth))
+	smux.Handle("GET /api/chirps/{id}", handleGetChirp(db, auth))
+	smux.Handle("PUT /api/chirps/{id}", handlePutChirp(db, auth, apiCfg.requireVerifiedEmail, apiCfg.chirpEditWindow, apiCfg.trends, apiCfg.moderation))
+	smux.Handle("DELETE /api/chirps/{id}", handleDeleteChirp(db, auth, apiCfg.trends))
+	smux.Handle("GET /api/chirps/{id}/history", handleGetChirpHistory(db, auth))
+	smux.Handle("GET /api/chirps/{id}/replies", handleGetChirpReplies(db, auth))
+	smux.Handle("GET /api/chirps/{id}/thread", handleGetChirpThread(db, auth))
+	smux.Handle("PUT /api/chirps/{id}/like", handlePutLike(db, auth))
+	smux.Handle("DELETE /api/chirps/{id}/like", handleDeleteLike(db, auth))
+	smux.Handle("POST /api/chirps/{id}/report", handlePostReport(db, auth))
+	smux.Handle("POST /api/chirps/{id}/rechirp", handlePostRechirp(db, auth, apiCfg.requireVerifiedEmail))
+	smux.Handle("DELETE /api/chirps/{id}/rechirp", handleDeleteRechirp(db, auth))
 
-	smux.Handle("POST /api/users", handlePostUsers(db))
+	smux.Handle("POST /api/users", handlePostUsers(db, apiCfg.passwordPolicy, apiCfg.mailer, apiCfg.jwtSecret, apiCfg.registrationMode, signupLimiter))
 	smux.Handle("GET /api/users", handleGetAllUsers(db))
 	smux.Handle("GET /api/users/{id}", handleGetUser(db))
-	smux.Handle("PUT /api/users", handlePutUsers(db, apiCfg.jwtSecret))
+	smux.Handle("GET /api/users/{id}/likes", handleGetUserLikes(db, auth))
+	smux.Handle("GET /api/users/{id}/mentions", handleGetUserMentions(db, auth))
+	smux.Handle("GET /api/hashtags/{tag}/chirps", handleGetHashtagChirps(db, auth))
+	smux.Handle("PUT /api/users/{id}/follow", handlePutFollow(db, auth))
+	smux.Handle("DELETE /api/users/{id}/follow", handleDeleteFollow(db, auth))
+	smux.Handle("GET /api/users/{id}/followers", handleGetFollowers(db))
+	smux.Handle("GET /api/users/{id}/following", handleGetFollowing(db))
+	smux.Handle("GET /api/timeline", handleGetTimeline(db, auth))
+	smux.Handle("GET /api/trends", handleGetTrends(apiCfg.trends))
+	uploadLimiter := newRateLimiter(apiCfg.mediaUploadRateLimit, time.Hour)
+	smux.Handle("POST /api/media", handlePostMedia(db, auth, apiCfg.mediaStore, apiCfg.mediaMaxBytes, uploadLimiter, make(chan struct{}, maxConcurrentImageProcessing)))
+	smux.Handle("GET /media/{name}", handleGetMedia(apiCfg.mediaStore))
+	smux.Handle("POST /api/users/verify", handlePostVerify(db, apiCfg.jwtSecret))
+	smux.Handle("PUT /api/users", handlePutUsers(db, auth, apiCfg.passwordPolicy, apiCfg.mailer, apiCfg.jwtSecret))
 
-	smux.Handle("POST /api/login", handlePostLogin(db, apiCfg.jwtSecret))
+	smux.Handle("POST /api/login", handlePostLogin(db, apiCfg.jwtSecret, throttle, respondWithTokens))
+	smux.Handle("POST /api/login/mfa", handlePostLoginMFA(db, apiCfg.jwtSecret, throttle, respondWithTokens))
 	smux.Handle("POST /api/refresh", handlePostRefresh(db, apiCfg.jwtSecret))
 	smux.Handle("POST /api/revoke", handlePostRevoke(db, apiCfg.jwtSecret))
+	smux.Handle("POST /api/logout", handlePostLogout(db, auth))
+	smux.Handle("POST /api/logout-all", handlePostLogoutAll(db, auth))
+
+	browserLogin := respondWithCookies(auth, apiCfg.cookieSecure)
+	smux.Handle("POST /api/browser/login", handlePostLogin(db, apiCfg.jwtSecret, throttle, browserLogin))
+	smux.Handle("POST /api/browser/login/mfa", handlePostLoginMFA(db, apiCfg.jwtSecret, throttle, browserLogin))
+	smux.Handle("POST /api/browser/refresh", handlePostBrowserRefresh(db, auth, apiCfg.cookieSecure))
+	smux.Handle("POST /api/browser/logout", handlePostBrowserLogout(db, auth, apiCfg.cookieSecure))
+
+	smux.Handle("GET /api/sessions", handleGetSessions(db, auth))
+	smux.Handle("DELETE /api/sessions/{id}", handleDeleteSession(db, auth))
+
+	smux.Handle("POST /api/tokens", handlePostTokens(db, auth))
+	smux.Handle("GET /api/tokens", handleGetTokens(db, auth))
+	smux.Handle("DELETE /api/tokens/{id}", handleDeleteToken(db, auth))
+
+	smux.Handle("POST /api/oauth/clients", handlePostOAuthClients(db, auth))
+	smux.Handle("GET /api/oauth/clients", handleGetOAuthClients(db, auth))
+	smux.Handle("DELETE /api/oauth/clients/{id}", handleDeleteOAuthClient(db, auth))
+
+	smux.Handle("POST /api/mfa/totp/enroll", handlePostTOTPEnroll(db, auth))
+	smux.Handle("POST /api/mfa/totp/confirm", handlePostTOTPConfirm(db, auth))
+	smux.Handle("POST /api/mfa/totp/disable", handlePostTOTPDisable(db, auth, throttle))
+
+	resetIPLimiter := newRateLimiter(resetRateLimitPerIP, time.Hour)
+	resetEmailLimiter := newRateLimiter(resetRateLimitPerEmail, time.Hour)
+	smux.Handle("POST /api/password/forgot", handlePostPasswordForgot(db, apiCfg.mailer, resetIPLimiter, resetEmailLimiter))
+	smux.Handle("POST /api/password/reset", handlePostPasswordReset(db, apiCfg.passwordPolicy))
+
+	smux.Handle("GET /oauth/authorize", handleGetAuthorize(db))
+	smux.Handle("POST /oauth/authorize", handlePostAuthorize(db, throttle))
+	smux.Handle("POST /oauth/token", handlePostOAuthToken(db, auth))
+	smux.Handle("POST /oauth/device_authorization", handlePostDeviceAuthorization(db))
+	smux.Handle("GET /device", handleGetDevice(db))
+	smux.Handle("POST /device", handlePostDevice(db, throttle))
+	smux.Handle("POST /api/device/approve", handlePostDeviceApprove(db, auth, throttle))
+	smux.Handle("POST /oauth/introspect", handlePostIntrospect(auth, apiCfg.introspectionClients))
 
 	smux.Handle("POST /api/polka/webhooks", handlePostPolkaWebhooks(db, apiCfg.polkaSecret))
 
+	smux.Handle("GET /admin/lockouts", middlewareAdmin(apiCfg.adminSecret, handleGetLockouts(db, throttle)))
+	smux.Handle("POST /admin/lockouts/unlock", middlewareAdmin(apiCfg.adminSecret, handlePostUnlock(db, throttle)))
+	smux.Handle("POST /admin/users/{id}/suspend", middlewareAdmin(apiCfg.adminSecret, handlePostSuspend(db, apiCfg.trends)))
+	smux.Handle("POST /admin/users/{id}/ban", middlewareAdmin(apiCfg.adminSecret, handlePostBan(db, apiCfg.trends)))
+	smux.Handle("GET /admin/moderation/rules", middlewareAdmin(apiCfg.adminSecret, handleGetModerationRules(apiCfg.moderation)))
+	smux.Handle("POST /admin/moderation/rules", middlewareAdmin(apiCfg.adminSecret, handlePostModerationRule(apiCfg.moderation)))
+	smux.Handle("PUT /admin/moderation/rules/{id}", middlewareAdmin(apiCfg.adminSecret, handlePutModerationRule(apiCfg.moderation)))
+	smux.Handle("DELETE /admin/moderation/rules/{id}", middlewareAdmin(apiCfg.adminSecret, handleDeleteModerationRule(apiCfg.moderation)))
+	smux.Handle("POST /admin/moderation/reload", middlewareAdmin(apiCfg.adminSecret, handlePostModerationReload(apiCfg.moderation)))
+	smux.Handle("GET /admin/moderation/audit", middlewareAdmin(apiCfg.adminSecret, handleGetModerationLog(db)))
+	smux.Handle("PUT /admin/users/{id}/moderator", middlewareAdmin(apiCfg.adminSecret, handlePutModerator(db)))
+	smux.Handle("DELETE /admin/users/{id}/moderator", middlewareAdmin(apiCfg.adminSecret, handleDeleteModerator(db)))
+	smux.Handle("GET /api/moderation/reports", handleGetReports(db, auth))
+	smux.Handle("POST /api/moderation/reports/{id}/claim", handlePostClaimReport(db, auth))
+	smux.Handle("POST /api/moderation/reports/{id}/resolve", handlePostResolveReport(db, auth, apiCfg.trends))
+	smux.Handle("GET /admin/moderation/flagged", middlewareAdmin(apiCfg.adminSecret, handleGetFlaggedChirps(db)))
+	smux.Handle("DELETE /admin/users/{id}/sanction", middlewareAdmin(apiCfg.adminSecret, handleDeleteSanction(db, apiCfg.trends)))
+	smux.Handle("POST /admin/invites", middlewareAdmin(apiCfg.adminSecret, handlePostInvites(db)))
+	smux.Handle("GET /admin/invites", middlewareAdmin(apiCfg.adminSecret, handleGetInvites(db)))
+	smux.Handle("DELETE /admin/invites/{id}", middlewareAdmin(apiCfg.adminSecret, handleDeleteInvite(db)))
+
 	return smux
 }
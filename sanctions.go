@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/trends"
+)
+
+// Returned when a suspended or banned user tries to log in or use a token, so that they can be told why.
+type sanctionError struct {
+	sanction database.Sanction
+}
+
+func (e *sanctionError) Error() string {
+	if e.sanction.Until != nil {
+		return fmt.Sprintf("Account is %s until %s", e.sanction.Kind, e.sanction.Until.Format(time.RFC3339))
+	}
+	return "Account is " + e.sanction.Kind
+}
+
+// Describes the sanction for display on a page, including the reason and appeal note.
+func (e *sanctionError) details() string {
+	msg := e.Error() + ": " + e.sanction.Reason
+	if e.sanction.AppealNote != "" {
+		msg += ". " + e.sanction.AppealNote
+	}
+	return msg
+}
+
+// Returns a *sanctionError if the user is currently suspended or banned.
+func checkSanction(db *database.DB, userId int) error {
+	sanction, err := db.GetSanction(userId)
+	if err != nil {
+		return err
+	}
+	if sanction != nil {
+		return &sanctionError{sanction: *sanction}
+	}
+	return nil
+}
+
+func respondWithSanction(w http.ResponseWriter, err *sanctionError) {
+	log.Println(getRequestID(w), "Rejecting sanctioned user:", err)
+	type response struct {
+		Error    string            `json:"error"`
+		Sanction database.Sanction `json:"sanction"`
+	}
+	respondWithJSON(w, 403, response{Error: err.Error(), Sanction: err.sanction})
+}
+
+// Responds and returns true if the user is suspended or banned.
+func rejectSanctioned(w http.ResponseWriter, db *database.DB, userId int) bool {
+	err := checkSanction(db, userId)
+	var serr *sanctionError
+	if errors.As(err, &serr) {
+		respondWithSanction(w, serr)
+		return true
+	}
+	if err != nil {
+		respondWithError(w, 500, "Potential database error", err)
+		return true
+	}
+	return false
+}
+
+func handlePostSuspend(db *database.DB, tracker *trends.Tracker) http.Handler {
+	return handlePostSanction(db, tracker, database.SanctionSuspended)
+}
+
+func handlePostBan(db *database.DB, tracker *trends.Tracker) http.Handler {
+	return handlePostSanction(db, tracker, database.SanctionBanned)
+}
+
+// Suspensions need a duration, bans last until lifted. Either replaces any earlier sanction.
+func handlePostSanction(db *database.DB, tracker *trends.Tracker, kind string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given user ID is not a number", err)
+			return
+		}
+		type parameters struct {
+			Reason     string `json:"reason"`
+			AppealNote string `json:"appeal_note"`
+			Duration   int    `json:"duration_seconds"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err = decoder.Decode(&params)
+		log.Println(rid, "handlePostSanction", kind, "user", id, params.Reason)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		sanction := database.Sanction{
+			Kind:       kind,
+			Reason:     strings.TrimSpace(params.Reason),
+			AppealNote: strings.TrimSpace(params.AppealNote),
+			CreatedAt:  time.Now(),
+		}
+		if sanction.Reason == "" {
+			respondWithError(w, 400, "A reason is required", nil)
+			return
+		}
+		if kind == database.SanctionSuspended {
+			if params.Duration <= 0 {
+				respondWithError(w, 400, "Suspensions need a positive duration_seconds", nil)
+				return
+			}
+			until := sanction.CreatedAt.Add(time.Duration(params.Duration) * time.Second)
+			sanction.Until = &until
+		}
+
+		err = db.SanctionUser(id, sanction)
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, 404, "User not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		// The user's hashtags stop counting towards trends
+		refreshTrends(w, db, tracker)
+		respondWithJSON(w, 200, sanction)
+	})
+}
+
+func handleDeleteSanction(db *database.DB, tracker *trends.Tracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given user ID is not a number", err)
+			return
+		}
+		log.Println(rid, "handleDeleteSanction for user", id)
+
+		err = db.LiftSanction(id)
+		if errors.Is(err, database.ErrUserNotFound) {
+			respondWithError(w, 404, "User not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		refreshTrends(w, db, tracker)
+		w.WriteHeader(200)
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+// Returns a random 128 bit identifier, hex encoded.
+func newRandomId() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func handleGetSessions(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		sessions, err := db.GetSessions(info.UserId)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+
+		type session struct {
+			database.Session
+			// Whether this is the session making the request
+			Current bool `json:"current"`
+		}
+		resp := make([]session, len(sessions))
+		for i, s := range sessions {
+			resp[i] = session{Session: s, Current: s.Id == info.SessionId}
+		}
+		respondWithJSON(w, 200, resp)
+	})
+}
+
+func handleDeleteSession(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		sessionId := r.PathValue("id")
+		log.Println(rid, "handleDeleteSession", sessionId, "for user", info.UserId)
+
+		err := db.RevokeSession(info.UserId, sessionId)
+		if errors.Is(err, database.ErrSessionNotFound) {
+			respondWithError(w, 404, "Session not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
+
+func handlePostLogoutAll(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		err := db.LogoutAll(info.UserId)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		log.Println(rid, "Logged out user", info.UserId, "everywhere")
+		w.WriteHeader(200)
+	})
+}
+
+// Logs out the access token making the request, along with the session it belongs to.
+func handlePostLogout(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, "")
+		if !ok {
+			return
+		}
+		if info.TokenId == "" {
+			respondWithError(w, 400, "Only access tokens can be logged out, personal access tokens have to be revoked", nil)
+			return
+		}
+
+		err := auth.denylist.deny(info.TokenId, info.ExpiresAt)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		if info.SessionId != "" {
+			err = db.RevokeSession(info.UserId, info.SessionId)
+			// The session may have been revoked already, which is fine
+			if err != nil && !errors.Is(err, database.ErrSessionNotFound) {
+				respondWithError(w, 500, "Potential database error", err)
+				return
+			}
+		}
+		log.Println(rid, "Logged out token", info.TokenId, "of user", info.UserId)
+		w.WriteHeader(200)
+	})
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Failed login attempts are tracked per account and per client IP. Every failure makes the key wait exponentially
+// longer before the next attempt, and once the threshold is reached the key is locked out entirely.
+type throttlePolicy struct {
+	threshold   int
+	backoffBase time.Duration
+	backoffMax  time.Duration
+	lockout     time.Duration
+	// Whether attempts have to wait for the one in progress, so that backoff can't be skipped by sending attempts in parallel
+	serial bool
+}
+
+var accountThrottlePolicy = throttlePolicy{threshold: 5, backoffBase: time.Second, backoffMax: time.Minute, lockout: 15 * time.Minute, serial: true}
+
+// IPs are commonly shared, so they get a higher threshold and gentler backoff than individual accounts, and allow parallel attempts.
+var ipThrottlePolicy = throttlePolicy{threshold: 20, backoffBase: 100 * time.Millisecond, backoffMax: 30 * time.Second, lockout: 15 * time.Minute}
+
+// Failure counts are forgotten once a key has been quiet for this long
+const loginFailureWindow = time.Hour
+
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+	// Attempts that have been let through but not yet failed or succeeded
+	pending int
+}
+
+// Entries that nothing refers to anymore can be forgotten.
+func (a *loginAttempts) stale(now time.Time) bool {
+	return a.pending == 0 && now.Sub(a.lastFailure) > loginFailureWindow && now.After(a.blockedUntil)
+}
+
+type lockout struct {
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+type loginThrottle struct {
+	attempts map[string]*loginAttempts
+	mux      sync.Mutex
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{attempts: make(map[string]*loginAttempts)}
+}
+
+// The key is derived from whatever email was submitted, whether or not an account exists for it, so that throttling behaves identically for known and unknown emails.
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+func policyFor(key string) throttlePolicy {
+	if strings.HasPrefix(key, "ip:") {
+		return ipThrottlePolicy
+	}
+	return accountThrottlePolicy
+}
+
+// An attempt that has been let through the throttle. It has to be settled with fail or release once the credentials have been checked.
+type loginReservation struct {
+	lt   *loginThrottle
+	keys []string
+}
+
+// Checks that none of the keys is backing off or locked out and reserves an attempt against all of them, in one critical section. Attempts in progress count towards the threshold, so parallel attempts can't get past the lockout before their failures are recorded. Returns how long the caller has to wait if the attempt isn't allowed.
+func (lt *loginThrottle) reserve(keys ...string) (*loginReservation, time.Duration) {
+	lt.mux.Lock()
+	defer lt.mux.Unlock()
+	now := time.Now()
+	var wait time.Duration
+	for _, k := range keys {
+		a, ok := lt.attempts[k]
+		if !ok {
+			continue
+		}
+		if a.stale(now) {
+			delete(lt.attempts, k)
+			continue
+		}
+		if d := a.blockedUntil.Sub(now); d > wait {
+			wait = d
+		}
+		policy := policyFor(k)
+		if a.pending > 0 && (policy.serial || a.failures+a.pending >= policy.threshold) {
+			wait = max(wait, policy.backoffBase)
+		}
+	}
+	if wait > 0 {
+		return nil, wait
+	}
+	for _, k := range keys {
+		a, ok := lt.attempts[k]
+		if !ok {
+			a = &loginAttempts{}
+			lt.attempts[k] = a
+		}
+		a.pending++
+	}
+	return &loginReservation{lt: lt, keys: keys}, 0
+}
+
+// Settles the attempt without counting it as a failure.
+func (r *loginReservation) release() {
+	r.lt.mux.Lock()
+	defer r.lt.mux.Unlock()
+	for _, k := range r.keys {
+		if a, ok := r.lt.attempts[k]; ok && a.pending > 0 {
+			a.pending--
+		}
+	}
+}
+
+// Records the attempt as failed against every key, and returns the keys that got locked out as a result.
+func (r *loginReservation) fail() []lockout {
+	lt := r.lt
+	lt.mux.Lock()
+	defer lt.mux.Unlock()
+	now := time.Now()
+	lockouts := []lockout{}
+	for _, k := range r.keys {
+		a, ok := lt.attempts[k]
+		if !ok {
+			a = &loginAttempts{}
+			lt.attempts[k] = a
+		}
+		if a.pending > 0 {
+			a.pending--
+		}
+		if now.Sub(a.lastFailure) > loginFailureWindow {
+			a.failures = 0
+		}
+		a.failures++
+		a.lastFailure = now
+
+		policy := policyFor(k)
+		if a.failures >= policy.threshold {
+			a.blockedUntil = now.Add(policy.lockout)
+			lockouts = append(lockouts, lockout{Key: k, Failures: a.failures, LockedUntil: a.blockedUntil})
+			continue
+		}
+		backoff := time.Duration(float64(policy.backoffBase) * math.Pow(2, float64(a.failures-1)))
+		a.blockedUntil = now.Add(min(backoff, policy.backoffMax))
+	}
+	return lockouts
+}
+
+// Settles a successful attempt, clearing the failure history of the key it was for. Other keys, such as the IP, keep theirs.
+func (r *loginReservation) succeed(key string) {
+	r.release()
+	r.lt.mux.Lock()
+	defer r.lt.mux.Unlock()
+	if a, ok := r.lt.attempts[key]; ok {
+		if a.pending > 0 {
+			// Other attempts for the key are still in progress and need the entry
+			*a = loginAttempts{pending: a.pending}
+		} else {
+			delete(r.lt.attempts, key)
+		}
+	}
+}
+
+// Forgets keys that have been quiet for longer than the failure window, which would otherwise pile up for every email and IP that ever failed once.
+func (lt *loginThrottle) sweep() int {
+	lt.mux.Lock()
+	defer lt.mux.Unlock()
+	now := time.Now()
+	swept := 0
+	for k, a := range lt.attempts {
+		if a.stale(now) {
+			delete(lt.attempts, k)
+			swept++
+		}
+	}
+	return swept
+}
+
+func (lt *loginThrottle) runSweep(interval time.Duration) {
+	for range time.Tick(interval) {
+		lt.sweep()
+	}
+}
+
+// Lifts any backoff or lockout on the key. Returns whether the key was being tracked at all.
+func (lt *loginThrottle) unlock(key string) bool {
+	lt.mux.Lock()
+	defer lt.mux.Unlock()
+	a, ok := lt.attempts[key]
+	if ok && a.pending > 0 {
+		*a = loginAttempts{pending: a.pending}
+	} else {
+		delete(lt.attempts, key)
+	}
+	return ok
+}
+
+// Lists the keys that are currently locked out, as opposed to merely backing off.
+func (lt *loginThrottle) lockedOut() []lockout {
+	lt.mux.Lock()
+	defer lt.mux.Unlock()
+	now := time.Now()
+	lockouts := []lockout{}
+	for k, a := range lt.attempts {
+		if a.failures >= policyFor(k).threshold && now.Before(a.blockedUntil) {
+			lockouts = append(lockouts, lockout{Key: k, Failures: a.failures, LockedUntil: a.blockedUntil})
+		}
+	}
+	return lockouts
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func respondWithTooManyRequests(w http.ResponseWriter, wait time.Duration, msg string) {
+	setRetryAfter(w, wait)
+	respondWithError(w, 429, msg, nil)
+}
+
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+}
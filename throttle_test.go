@@ -0,0 +1,203 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Fails the given number of attempts in a row, skipping the backoff in between, and returns the entry for the key.
+func failAttempts(t *testing.T, lt *loginThrottle, key string, n int) *loginAttempts {
+	t.Helper()
+	for i := range n {
+		if a, ok := lt.attempts[key]; ok {
+			a.blockedUntil = time.Time{}
+		}
+		attempt, wait := lt.reserve(key)
+		if attempt == nil {
+			t.Fatalf("Attempt %d has to wait %v", i+1, wait)
+		}
+		attempt.fail()
+	}
+	return lt.attempts[key]
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	account, ip := accountThrottleKey("user@example.com"), ipThrottleKey("192.0.2.1")
+	tests := []struct {
+		name     string
+		key      string
+		failures int
+		want     time.Duration
+		locked   bool
+	}{
+		{"account, first failure", account, 1, time.Second, false},
+		{"account, second failure", account, 2, 2 * time.Second, false},
+		{"account, below the threshold", account, 4, 8 * time.Second, false},
+		{"account, at the threshold", account, 5, 15 * time.Minute, true},
+		{"ip, first failure", ip, 1, 100 * time.Millisecond, false},
+		{"ip, capped", ip, 19, 30 * time.Second, false},
+		{"ip, at the threshold", ip, 20, 15 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lt := newLoginThrottle()
+			a := failAttempts(t, lt, tt.key, tt.failures)
+			if got := a.blockedUntil.Sub(a.lastFailure); got != tt.want {
+				t.Errorf("Blocked for %v, want %v", got, tt.want)
+			}
+			if a.failures != tt.failures || a.pending != 0 {
+				t.Errorf("Tracked %+v, want %d failures and nothing pending", a, tt.failures)
+			}
+			if locked := len(lt.lockedOut()) == 1; locked != tt.locked {
+				t.Errorf("Locked out = %v, want %v", locked, tt.locked)
+			}
+			if _, wait := lt.reserve(tt.key); wait <= 0 || wait > tt.want {
+				t.Errorf("Next attempt waits %v, want up to %v", wait, tt.want)
+			}
+		})
+	}
+}
+
+func TestThrottleLockoutReported(t *testing.T) {
+	lt := newLoginThrottle()
+	account, ip := accountThrottleKey("user@example.com"), ipThrottleKey("192.0.2.1")
+	failAttempts(t, lt, account, accountThrottlePolicy.threshold-1)
+	lt.attempts[account].blockedUntil = time.Time{}
+
+	attempt, _ := lt.reserve(account, ip)
+	lockouts := attempt.fail()
+	if len(lockouts) != 1 || lockouts[0].Key != account || lockouts[0].Failures != accountThrottlePolicy.threshold {
+		t.Errorf("Lockouts = %+v, want only %s", lockouts, account)
+	}
+}
+
+func TestThrottleParallelAttempts(t *testing.T) {
+	account, ip := accountThrottleKey("user@example.com"), ipThrottleKey("192.0.2.1")
+	tests := []struct {
+		name    string
+		key     string
+		pending int
+		failed  int
+		allowed bool
+	}{
+		{"account, none in progress", account, 0, 0, true},
+		{"account, one in progress", account, 1, 0, false},
+		{"ip, several in progress", ip, 5, 0, true},
+		{"ip, in progress reaching the threshold", ip, 20, 0, false},
+		{"ip, failed and in progress reaching the threshold", ip, 10, 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lt := newLoginThrottle()
+			lt.attempts[tt.key] = &loginAttempts{failures: tt.failed, lastFailure: time.Now(), pending: tt.pending}
+			attempt, wait := lt.reserve(tt.key)
+			if allowed := attempt != nil; allowed != tt.allowed {
+				t.Fatalf("Allowed = %v with wait %v, want %v", allowed, wait, tt.allowed)
+			}
+			if attempt != nil {
+				if lt.attempts[tt.key].pending != tt.pending+1 {
+					t.Errorf("Pending = %d, want %d", lt.attempts[tt.key].pending, tt.pending+1)
+				}
+				attempt.release()
+				if lt.attempts[tt.key].pending != tt.pending || lt.attempts[tt.key].failures != tt.failed {
+					t.Errorf("Released to %+v, want it unchanged", lt.attempts[tt.key])
+				}
+			}
+		})
+	}
+}
+
+func TestThrottleSucceed(t *testing.T) {
+	account, ip := accountThrottleKey("user@example.com"), ipThrottleKey("192.0.2.1")
+	tests := []struct {
+		name  string
+		other bool
+	}{
+		{"only attempt", false},
+		// Another attempt for the account is let through on a different IP before this one settles
+		{"other attempt in progress", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lt := newLoginThrottle()
+			lt.attempts[account] = &loginAttempts{failures: 3, lastFailure: time.Now()}
+			lt.attempts[ip] = &loginAttempts{failures: 3, lastFailure: time.Now()}
+			attempt, _ := lt.reserve(account, ip)
+			if tt.other {
+				lt.attempts[account].pending++
+			}
+			attempt.succeed(account)
+
+			a, ok := lt.attempts[account]
+			if tt.other && (!ok || *a != loginAttempts{pending: 1}) {
+				t.Errorf("Account tracked as %+v, want only the attempt in progress", a)
+			}
+			if !tt.other && ok {
+				t.Errorf("Account still tracked as %+v", a)
+			}
+			if a := lt.attempts[ip]; a.failures != 3 || a.pending != 0 {
+				t.Errorf("IP tracked as %+v, want its failures kept", a)
+			}
+		})
+	}
+}
+
+func TestThrottleFailureWindow(t *testing.T) {
+	lt := newLoginThrottle()
+	key := accountThrottleKey("user@example.com")
+	failAttempts(t, lt, key, accountThrottlePolicy.threshold-1)
+	lt.attempts[key].lastFailure = time.Now().Add(-loginFailureWindow - time.Minute)
+
+	a := failAttempts(t, lt, key, 1)
+	if a.failures != 1 {
+		t.Errorf("Failures = %d after the window passed, want 1", a.failures)
+	}
+}
+
+func TestThrottleSweep(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-loginFailureWindow - time.Minute)
+	tests := []struct {
+		name     string
+		attempts loginAttempts
+		swept    bool
+	}{
+		{"quiet", loginAttempts{failures: 2, lastFailure: old, blockedUntil: old}, true},
+		{"recent failure", loginAttempts{failures: 2, lastFailure: now, blockedUntil: now}, false},
+		{"still locked out", loginAttempts{failures: 5, lastFailure: old, blockedUntil: now.Add(time.Minute)}, false},
+		{"in progress", loginAttempts{lastFailure: old, blockedUntil: old, pending: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lt := newLoginThrottle()
+			a := tt.attempts
+			lt.attempts["account:user@example.com"] = &a
+			want := 0
+			if tt.swept {
+				want = 1
+			}
+			if got := lt.sweep(); got != want || len(lt.attempts) != 1-want {
+				t.Errorf("Swept %d, leaving %d, want %d swept", got, len(lt.attempts), want)
+			}
+		})
+	}
+}
+
+func TestAccountThrottleKey(t *testing.T) {
+	tests := []struct {
+		email string
+		want  string
+	}{
+		{"user@example.com", "account:user@example.com"},
+		{"User@Example.COM", "account:user@example.com"},
+		{"  user@example.com\n", "account:user@example.com"},
+		{"nobody", "account:nobody"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			if got := accountThrottleKey(tt.email); got != tt.want {
+				t.Errorf("accountThrottleKey(%q) = %q, want %q", tt.email, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+)
+
+func handlePostTokens(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		type parameters struct {
+			Name       string   `json:"name"`
+			Scopes     []string `json:"scopes"`
+			Expiration int      `json:"expires_in_seconds"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		log.Println(rid, "handlePostTokens for user", info.UserId, params.Name, params.Scopes)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		name := strings.TrimSpace(params.Name)
+		if name == "" {
+			respondWithError(w, 400, "Token name is required", nil)
+			return
+		}
+		if len(params.Scopes) == 0 {
+			respondWithError(w, 400, fmt.Sprintf("At least one scope is required, choose from %v", grantableScopes), nil)
+			return
+		}
+		for _, s := range params.Scopes {
+			if !slices.Contains(grantableScopes, s) {
+				respondWithError(w, 400, fmt.Sprintf("Unknown scope %q, choose from %v", s, grantableScopes), nil)
+				return
+			}
+		}
+		if params.Expiration < 0 {
+			respondWithError(w, 400, "Expiration must not be negative", nil)
+			return
+		}
+		// No expiration means the token is valid until revoked
+		var expiresAt *time.Time
+		if params.Expiration > 0 {
+			t := time.Now().Add(time.Duration(params.Expiration) * time.Second)
+			expiresAt = &t
+		}
+
+		secret, err := newRandomToken()
+		if err != nil {
+			respondWithError(w, 500, "Error generating token", err)
+			return
+		}
+		tokenString := personalAccessTokenPrefix + secret
+		scopes := slices.Clone(params.Scopes)
+		slices.Sort(scopes)
+		scopes = slices.Compact(scopes)
+		token, err := db.CreatePersonalAccessToken(info.UserId, name, scopes, hashToken(tokenString), expiresAt)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+
+		// This is the only time the token itself is ever shown
+		type response struct {
+			database.PersonalAccessToken
+			Token string `json:"token"`
+		}
+		respondWithJSON(w, 201, response{PersonalAccessToken: token, Token: tokenString})
+	})
+}
+
+func handleGetTokens(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		tokens, err := db.GetPersonalAccessTokens(info.UserId)
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		respondWithJSON(w, 200, tokens)
+	})
+}
+
+func handleDeleteToken(db *database.DB, auth *authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		info, ok := auth.require(w, r, scopeAccount)
+		if !ok {
+			return
+		}
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			respondWithError(w, 400, "Given token ID is not a number", err)
+			return
+		}
+		log.Println(rid, "handleDeleteToken", id, "for user", info.UserId)
+
+		err = db.RevokePersonalAccessToken(info.UserId, id)
+		if errors.Is(err, database.ErrTokenNotFound) {
+			respondWithError(w, 404, "Token not found", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Potential database error", err)
+			return
+		}
+		w.WriteHeader(200)
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/entities"
+	"github.com/madsbv/go-server-exercise/internal/trends"
+)
+
+const defaultTrendsLimit = 10
+const maxTrendsLimit = 50
+
+// Replays the hashtags of stored chirps. Needed on startup, and whenever uses disappear because chirps are edited or deleted or their authors are sanctioned.
+func rebuildTrends(db *database.DB, tracker *trends.Tracker) error {
+	uses, err := db.GetHashtagUses(time.Now().Add(-tracker.Horizon()))
+	if err != nil {
+		return err
+	}
+	replay := make([]trends.Use, len(uses))
+	for i, u := range uses {
+		replay[i] = trends.Use{Tag: u.Tag, At: u.At}
+	}
+	tracker.Rebuild(replay)
+	return nil
+}
+
+// For use after the change that made the rebuild necessary has already succeeded, so failures are only logged.
+func refreshTrends(w http.ResponseWriter, db *database.DB, tracker *trends.Tracker) {
+	err := rebuildTrends(db, tracker)
+	if err != nil {
+		log.Println(getRequestID(w), "Failed to rebuild hashtag trends:", err)
+	}
+}
+
+// Suspensions run out without anyone doing anything, so their users' hashtags are brought back by rebuilding regularly.
+func runTrendRebuilds(db *database.DB, tracker *trends.Tracker, interval time.Duration) {
+	for range time.Tick(interval) {
+		err := rebuildTrends(db, tracker)
+		if err != nil {
+			log.Println("Failed to rebuild hashtag trends:", err)
+		}
+	}
+}
+
+func recordTrends(tracker *trends.Tracker, chirp Chirp) {
+	for _, e := range chirp.Entities {
+		if e.Type == entities.TypeHashtag {
+			tracker.Record(e.Value, chirp.CreatedAt)
+		}
+	}
+}
+
+// Windows are shown the way they are usually configured, as in "24h" rather than "24h0m0s".
+func formatWindow(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+func handleGetTrends(tracker *trends.Tracker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		window := tracker.Windows()[0]
+		if s := query.Get("window"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				respondWithError(w, 400, "Invalid window", err)
+				return
+			}
+			window = d
+		}
+		limit := defaultTrendsLimit
+		if s := query.Get("limit"); s != "" {
+			l, err := strconv.Atoi(s)
+			if err != nil || l < 1 || l > maxTrendsLimit {
+				respondWithError(w, 400, fmt.Sprintf("limit must be a number between 1 and %d", maxTrendsLimit), err)
+				return
+			}
+			limit = l
+		}
+
+		top, err := tracker.Top(window, limit, time.Now())
+		if errors.Is(err, trends.ErrUnknownWindow) {
+			windows := []string{}
+			for _, d := range tracker.Windows() {
+				windows = append(windows, formatWindow(d))
+			}
+			respondWithError(w, 400, "window must be one of "+strings.Join(windows, ", "), err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, 500, "Error getting trends", err)
+			return
+		}
+
+		type response struct {
+			Window string         `json:"window"`
+			Trends []trends.Trend `json:"trends"`
+		}
+		respondWithJSON(w, 200, response{Window: formatWindow(window), Trends: top})
+	})
+}
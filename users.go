@@ -2,18 +2,23 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 
 	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/mailer"
+	"github.com/madsbv/go-server-exercise/internal/password"
 )
 
-func handlePostUsers(db *database.DB) http.Handler {
+func handlePostUsers(db *database.DB, policy *password.Policy, m mailer.Mailer, jwtSecret []byte, registrationMode string, limiter *rateLimiter) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		type parameters struct {
 			Email    string `json:"email"`
 			Password string `json:"password"`
+			// Required when registration is invite only
+			Invite string `json:"invite"`
 		}
 
 		decoder := json.NewDecoder(r.Body)
@@ -26,12 +31,57 @@ func handlePostUsers(db *database.DB) http.Handler {
 			return
 		}
 
-		user, err := db.CreateUser(params.Email, params.Password)
+		if registrationMode == registrationClosed {
+			respondWithError(w, 403, "Registration is closed", nil)
+			return
+		}
+		if registrationMode == registrationInvite && params.Invite == "" {
+			respondWithError(w, 403, "Registration requires an invite code", nil)
+			return
+		}
+
+		err = validateEmail(params.Email)
+		if err != nil {
+			respondWithError(w, 400, "Invalid email address", err)
+			return
+		}
+
+		err = policy.Validate(params.Email, params.Password)
+		if err != nil {
+			respondWithPolicyError(w, err)
+			return
+		}
+
+		// The signup is counted before the user is created, so that concurrent signups can't all get past the limit, and given back if creating the user fails
+		ip := clientIP(r)
+		if !limiter.allow(ip) {
+			respondWithTooManyRequests(w, limiter.retryAfter(ip), "Too many signups from this address, try again later")
+			return
+		}
+
+		var user database.SafeUser
+		if registrationMode == registrationInvite {
+			user, err = db.CreateUserWithInvite(params.Email, params.Password, hashToken(params.Invite))
+		} else {
+			user, err = db.CreateUser(params.Email, params.Password)
+		}
+		if err != nil {
+			limiter.release(ip)
+		}
+		if errors.Is(err, database.ErrEmailTaken) {
+			respondWithError(w, 409, err.Error(), err)
+			return
+		}
+		if errors.Is(err, database.ErrInvalidInvite) {
+			respondWithError(w, 403, "Invite code is invalid, expired or used up", err)
+			return
+		}
 		if err != nil {
 			log.Printf("Database error when creating user: %v", err)
 			respondWithError(w, 500, "Error handling request", err)
 			return
 		}
+		trySendVerificationEmail(getRequestID(w), m, user, jwtSecret)
 
 		respondWithJSON(w, 201, user)
 	})
@@ -43,6 +93,7 @@ func handleGetAllUsers(db *database.DB) http.Handler {
 		if err != nil {
 			log.Println("Error getting list of users")
 			respondWithError(w, 500, "Error handling request", err)
+			return
 		}
 		respondWithJSON(w, 200, users)
 	})
@@ -54,15 +105,33 @@ func handleGetUser(db *database.DB) http.Handler {
 		id, err := strconv.Atoi(requestedId)
 		if err != nil {
 			log.Printf("Error serving GetUser request for requested id %v: Looks like it is not an integer", requestedId)
+			respondWithError(w, 400, "Given user ID is not a number", err)
+			return
 		}
 
-		user, err := db.GetUser(id)
+		user, err := db.GetUserProfile(id)
 		if err != nil {
 			log.Println("Error getting user with id", id, err)
 			// NOTE: It might be worth distinguishing between internal database error, and invalid id in request. How to do that?
 			respondWithError(w, 404, "Error handling request", err)
+			return
 		}
 
 		respondWithJSON(w, 200, user)
 	})
 }
+
+func respondWithPolicyError(w http.ResponseWriter, err error) {
+	perr, ok := err.(*password.PolicyError)
+	if !ok {
+		respondWithError(w, 500, "Error validating password", err)
+		return
+	}
+	log.Println(getRequestID(w), "Rejecting password:", perr)
+
+	type response struct {
+		Error      string               `json:"error"`
+		Violations []password.Violation `json:"violations"`
+	}
+	respondWithJSON(w, 400, response{Error: "Password does not meet requirements", Violations: perr.Violations})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/mailer"
+)
+
+const expirationVerifySeconds = 60 * 60 * 24 // 1 day
+const verifyIssuer = "chirpy-verify-email"
+
+// Binds the token to the address it was sent to, so that it can't be used to verify a different address after the user changes it.
+type emailClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// Accepts bare addresses only, e.g. "walt@breakingbad.com" but not "Walt <walt@breakingbad.com>".
+func validateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return err
+	}
+	if addr.Address != email {
+		return errors.New("Email address must not include a display name")
+	}
+	return nil
+}
+
+func sendVerificationEmail(m mailer.Mailer, user database.SafeUser, jwtSecret []byte) error {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, emailClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    verifyIssuer,
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expirationVerifySeconds * time.Second)),
+			Subject:   fmt.Sprint(user.Id),
+		},
+	}).SignedString(jwtSecret)
+	if err != nil {
+		return err
+	}
+
+	return m.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf(`Welcome to Chirpy!
+
+To verify your email address, send the following token to POST /api/users/verify within 24 hours:
+
+%s
+
+If you didn't sign up for Chirpy, you can ignore this email.
+`, token),
+	})
+}
+
+func handlePostVerify(db *database.DB, jwtSecret []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rid := getRequestID(w)
+		type parameters struct {
+			Token string `json:"token"`
+		}
+		decoder := json.NewDecoder(r.Body)
+		params := parameters{}
+		err := decoder.Decode(&params)
+		log.Println(rid, "handlePostVerify", params.Token)
+		if err != nil {
+			respondWithError(w, 500, "Failed to decode request body", err)
+			return
+		}
+
+		claims := emailClaims{}
+		_, err = jwt.ParseWithClaims(params.Token, &claims, func(token *jwt.Token) (interface{}, error) {
+			return jwtSecret, nil
+		}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithIssuer(verifyIssuer))
+		if err != nil {
+			respondWithError(w, 401, "Invalid verification token", err)
+			return
+		}
+
+		id, err := strconv.Atoi(claims.Subject)
+		if err != nil {
+			respondWithError(w, 401, "Given user ID is not a number", err)
+			return
+		}
+
+		user, err := db.VerifyEmail(id, claims.Email)
+		if err != nil {
+			respondWithError(w, 400, "Couldn't verify email address", err)
+			return
+		}
+		log.Println(rid, "Verified email for user", id)
+		respondWithJSON(w, 200, user)
+	})
+}
+
+// Sends a verification email without failing the surrounding request. Users can get a new email by submitting their address to PUT /api/users again.
+func trySendVerificationEmail(rid string, m mailer.Mailer, user database.SafeUser, jwtSecret []byte) {
+	err := sendVerificationEmail(m, user, jwtSecret)
+	if err != nil {
+		log.Println(rid, "Error sending verification email to", user.Email, err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/madsbv/go-server-exercise/internal/database"
+	"github.com/madsbv/go-server-exercise/internal/mailer"
+)
+
+// Keeps sent messages in memory.
+type testMailer struct {
+	sent []mailer.Message
+}
+
+func (m *testMailer) Send(msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Finds the verification token in the last message sent.
+func (m *testMailer) verificationToken(t *testing.T) string {
+	t.Helper()
+	if len(m.sent) == 0 {
+		t.Fatal("No email sent")
+	}
+	_, after, ok := strings.Cut(m.sent[len(m.sent)-1].Body, "within 24 hours:\n\n")
+	if !ok {
+		t.Fatalf("No token in %q", m.sent[len(m.sent)-1].Body)
+	}
+	token, _, _ := strings.Cut(after, "\n")
+	return token
+}
+
+func TestValidateEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		valid bool
+	}{
+		{"walt@breakingbad.com", true},
+		{"walt.white+chirpy@breakingbad.com", true},
+		{"Walt <walt@breakingbad.com>", false},
+		{"<walt@breakingbad.com>", false},
+		{"walt", false},
+		{"walt@", false},
+		{"", false},
+		{"walt@breakingbad.com, jesse@breakingbad.com", false},
+		{" walt@breakingbad.com", false},
+		{"walt@breakingbad.com\r\nBcc: jesse@breakingbad.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			if err := validateEmail(tt.email); (err == nil) != tt.valid {
+				t.Errorf("validateEmail(%q) = %v, want valid %v", tt.email, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	signed := func(claims emailClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testJWTSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	tests := []struct {
+		name   string
+		token  func(m *testMailer, user database.SafeUser, db *database.DB) string
+		status int
+	}{
+		{"token from the email", func(m *testMailer, user database.SafeUser, db *database.DB) string {
+			return m.verificationToken(t)
+		}, 200},
+		{"address changed since", func(m *testMailer, user database.SafeUser, db *database.DB) string {
+			token := m.verificationToken(t)
+			_, err := db.UpdateUser(user.Id, "other@example.com", "correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return token
+		}, 400},
+		{"expired", func(m *testMailer, user database.SafeUser, db *database.DB) string {
+			return signed(emailClaims{Email: user.Email, RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    verifyIssuer,
+				Subject:   fmt.Sprint(user.Id),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+			}})
+		}, 401},
+		{"other issuer", func(m *testMailer, user database.SafeUser, db *database.DB) string {
+			return signed(emailClaims{Email: user.Email, RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "chirpy",
+				Subject:   fmt.Sprint(user.Id),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			}})
+		}, 401},
+		{"access token", func(m *testMailer, user database.SafeUser, db *database.DB) string {
+			resp, err := issueLoginTokens(db, user, expirationAccessSeconds, testJWTSecret, httptest.NewRequest("POST", "/api/login", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return resp.Token
+		}, 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			m := &testMailer{}
+			user, err := db.CreateUser("user@example.com", "correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = sendVerificationEmail(m, user, testJWTSecret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.sent[0].To != user.Email {
+				t.Errorf("Sent to %q, want %q", m.sent[0].To, user.Email)
+			}
+
+			r := httptest.NewRequest("POST", "/api/users/verify", strings.NewReader(`{"token": "`+tt.token(m, user, db)+`"}`))
+			w := httptest.NewRecorder()
+			handlePostVerify(db, testJWTSecret).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("Status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			user, err = db.GetUser(user.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.EmailVerified != (tt.status == 200) {
+				t.Errorf("EmailVerified = %v after status %d", user.EmailVerified, w.Code)
+			}
+		})
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	tests := []struct {
+		name     string
+		required bool
+		verified bool
+		status   int
+	}{
+		{"not required", false, false, 201},
+		{"verified", true, true, 201},
+		{"unverified", true, false, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			author, err := db.CreateUser("author@example.com", "correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			chirp, err := db.CreateChirp(database.Chirp{Body: "chirp", AuthorId: author.Id})
+			if err != nil {
+				t.Fatal(err)
+			}
+			user, err := db.CreateUser("user@example.com", "correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.verified {
+				_, err = db.VerifyEmail(user.Id, user.Email)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			resp, err := issueLoginTokens(db, user, expirationAccessSeconds, testJWTSecret, httptest.NewRequest("POST", "/api/login", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest("POST", fmt.Sprintf("/api/chirps/%d/rechirp", chirp.Id), nil)
+			r.SetPathValue("id", fmt.Sprint(chirp.Id))
+			r.Header.Set("Authorization", "Bearer "+resp.Token)
+			w := httptest.NewRecorder()
+			handlePostRechirp(db, newTestAuthenticator(t, db), tt.required).ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("Rechirp: status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}